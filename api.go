@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var controlFlag = flag.String("control", "", "address to serve status and approvals for 'blart ctl' on, where ':port' listens on loopback only")
+
+// controlAddr defaults addr to loopback when it has no host, as the
+// control API can approve changes and shows their diffs.
+func controlAddr(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return "127.0.0.1" + addr
+	}
+	return addr
+}
+
+// api is everything reported on by /status, /metrics and the
+// status file. Optional parts are nil when not enabled.
+type api struct {
+	child       *child
+	health      *health
+	gate        *approval
+	certs       *certMonitor
+	fingerprint *fingerprint
+}
+
+// status is the document served at /status, and written to
+// -status-file.
+type status struct {
+	Pid         int            `json:"pid"`
+	Running     bool           `json:"running"`
+	Ready       bool           `json:"ready"`
+	Reason      string         `json:"reason"`
+	Fingerprint string         `json:"fingerprint"`
+	Pending     *pendingChange `json:"pending,omitempty"`
+	Certs       []certStatus   `json:"certs,omitempty"`
+	Tree        *proc          `json:"tree,omitempty"`
+}
+
+// summary is the status without the pending diffs and process tree,
+// which are expensive to build, for callers that don't need them.
+func (a *api) summary() status {
+	s := status{
+		Pid:         a.child.pid(),
+		Running:     a.child.running(),
+		Fingerprint: a.fingerprint.get(),
+	}
+	s.Ready, s.Reason = a.health.ready()
+	if !s.Running {
+		s.Ready, s.Reason = false, "child not running"
+	}
+	if a.certs != nil {
+		s.Certs = a.certs.status()
+	}
+	redaction.status(&s)
+	return s
+}
+
+func (a *api) status() status {
+	s := a.summary()
+	if a.gate != nil {
+		s.Pending = a.gate.pending()
+	}
+	if s.Running {
+		s.Tree, _ = processTree(s.Pid)
+	}
+	// the summary is already redacted, and pending diffs are as
+	// they're made
+	redaction.tree(s.Tree)
+	return s
+}
+
+// serveHealth serves the health checks and metrics, which are meant
+// to be reachable by orchestrators and scrapers.
+func (a *api) serveHealth(ln net.Listener) {
+	mux := http.NewServeMux()
+	handleHealth(mux, a.child, a.health)
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, a.summary())
+	})
+	serve(ln, mux)
+}
+
+// serveControl serves the status and approval endpoints used by
+// `blart ctl`.
+func (a *api) serveControl(ln net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encodeJSON(w, a.status())
+	})
+	// decide runs fn, and then the function it returns, if any, once
+	// the reply has been sent
+	decide := func(fn func(*approval) (func(), error)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if a.gate == nil {
+				http.Error(w, "approval is not enabled", http.StatusNotFound)
+				return
+			}
+			then, err := fn(a.gate)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			fmt.Fprintln(w, "ok")
+			if then != nil {
+				if f, ok := w.(http.Flusher); ok {
+					f.Flush()
+				}
+				go then()
+			}
+		}
+	}
+	mux.HandleFunc("/approve", decide((*approval).approve))
+	mux.HandleFunc("/reject", decide(func(g *approval) (func(), error) {
+		return nil, g.reject()
+	}))
+	serve(ln, mux)
+}
+
+func serve(ln net.Listener, mux *http.ServeMux) {
+	log.Println("==> serving http on", ln.Addr())
+	if err := http.Serve(ln, mux); err != nil {
+		log.Println("==> error:", err)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	approveFlag        = flag.Bool("approve", false, "hold changes until approved with 'blart ctl approve'")
+	approveTimeoutFlag = flag.Duration("approve-timeout", 0, "approve held changes automatically after this long (default never)")
+)
+
+var errNothingPending = errors.New("no changes pending approval")
+
+// actionOrder is the order approved actions run in. Promotion goes
+// first, so that a restart or reload picks up the promoted files.
+var actionOrder = []string{"promotion", "restart", "reload"}
+
+// approval holds debounced changes until a human approves or rejects
+// them. Diffs are shown against what was last approved.
+type approval struct {
+	files  []string
+	health *health
+
+	mu      sync.Mutex
+	applied snapshot
+	actions map[string]func()
+	since   time.Time
+	timer   *time.Timer
+	// generation counts the sets of changes held, so that a timeout
+	// firing for one set never approves the next
+	generation int
+	// applying serializes the application of approved sets
+	applying sync.Mutex
+}
+
+// pendingChange describes held changes for status output.
+type pendingChange struct {
+	Since   time.Time  `json:"since"`
+	Actions []string   `json:"actions"`
+	Diffs   []fileDiff `json:"diffs"`
+}
+
+func newApproval(files []string, h *health) *approval {
+	return &approval{
+		files:   files,
+		health:  h,
+		applied: takeSnapshot(files),
+	}
+}
+
+// gate wraps fn so that calling it holds the change for approval
+// instead of running it.
+func (a *approval) gate(name string, fn func()) func() {
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.actions == nil {
+			a.actions = make(map[string]func())
+			a.since = time.Now()
+			a.generation++
+			if *approveTimeoutFlag > 0 {
+				gen := a.generation
+				a.timer = time.AfterFunc(*approveTimeoutFlag, func() {
+					a.timedOut(gen)
+				})
+			}
+		}
+		a.actions[name] = fn
+		log.Printf("==> holding %s for approval", name)
+		// the running config is unchanged, so there's no reason
+		// to be taken out of rotation while waiting
+		a.health.reloadHeld()
+	}
+}
+
+// timedOut approves the set of changes held in generation gen, if
+// they're still held.
+func (a *approval) timedOut(gen int) {
+	a.mu.Lock()
+	if gen != a.generation || a.actions == nil {
+		// decided on before the timer could be stopped
+		a.mu.Unlock()
+		return
+	}
+	log.Println("==> approval timed out, approving")
+	apply, _ := a.approveLocked()
+	a.mu.Unlock()
+	apply()
+}
+
+// approve releases the held changes, and returns the function that
+// applies them. Applying can take as long as a restart, or exit blart
+// with -exit-on-change, so callers with someone waiting on them should
+// reply first.
+func (a *approval) approve() (func(), error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.approveLocked()
+}
+
+func (a *approval) approveLocked() (func(), error) {
+	actions, err := a.release()
+	if err != nil {
+		return nil, err
+	}
+	a.applied = takeSnapshot(a.files)
+	log.Println("==> changes approved")
+	return func() { a.apply(actions) }, nil
+}
+
+func (a *approval) apply(actions map[string]func()) {
+	a.applying.Lock()
+	defer a.applying.Unlock()
+	for _, name := range actionOrder {
+		fn, ok := actions[name]
+		if !ok {
+			continue
+		}
+		// held actions were marked as no longer in progress
+		a.health.reloadStarted()
+		fn()
+	}
+}
+
+func (a *approval) reject() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.release(); err != nil {
+		return err
+	}
+	log.Println("==> changes rejected")
+	return nil
+}
+
+func (a *approval) release() (map[string]func(), error) {
+	if a.actions == nil {
+		return nil, errNothingPending
+	}
+	actions := a.actions
+	a.actions = nil
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+	return actions, nil
+}
+
+func (a *approval) pending() *pendingChange {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.actions == nil {
+		return nil
+	}
+	p := &pendingChange{
+		Since: a.since,
+		Diffs: diffSnapshots(a.applied, takeSnapshot(a.files)),
+	}
+	for name := range a.actions {
+		p.Actions = append(p.Actions, name)
+	}
+	sort.Strings(p.Actions)
+	return p
+}
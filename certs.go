@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	certWarnFlag         = flag.String("cert-warn", "30,7,1", "days before a watched certificate expires to warn at, split by ','")
+	certRenewFlag        = flag.String("cert-renew", "", "shell command to run when a certificate crosses a -cert-warn threshold")
+	certRenewTimeoutFlag = flag.Duration("cert-renew-timeout", 5*time.Minute, "time -cert-renew may run before it's killed")
+	certIntervalFlag     = flag.Duration("cert-interval", time.Hour, "time between certificate expiry checks")
+)
+
+// certStatus is the expiry of a watched certificate.
+type certStatus struct {
+	File     string    `json:"file"`
+	Subject  string    `json:"subject"`
+	NotAfter time.Time `json:"not_after"`
+	DaysLeft int       `json:"days_left"`
+}
+
+// certMonitor warns as watched PEM certificates approach expiry, and
+// runs the renewal hook when a threshold is crossed.
+type certMonitor struct {
+	files      []string
+	thresholds []int // descending
+
+	mu     sync.Mutex
+	certs  map[string]certStatus
+	warned map[string]int // index of the last threshold warned about
+}
+
+func parseThresholds(s string) ([]int, error) {
+	var thresholds []int
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t == "" {
+			continue
+		}
+		days, err := strconv.Atoi(t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -cert-warn threshold: %s", t)
+		}
+		thresholds = append(thresholds, days)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(thresholds)))
+	return thresholds, nil
+}
+
+func newCertMonitor(files []string, thresholds []int) *certMonitor {
+	return &certMonitor{
+		files:      files,
+		thresholds: thresholds,
+		certs:      make(map[string]certStatus),
+		warned:     make(map[string]int),
+	}
+}
+
+// readCert returns the expiry of the first certificate in file, or
+// false if it doesn't contain one.
+func readCert(file string) (certStatus, bool) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return certStatus{}, false
+	}
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			return certStatus{}, false
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return certStatus{}, false
+		}
+		return certStatus{
+			File:     file,
+			Subject:  cert.Subject.CommonName,
+			NotAfter: cert.NotAfter,
+			DaysLeft: int(cert.NotAfter.Sub(time.Now()).Hours() / 24),
+		}, true
+	}
+}
+
+func (m *certMonitor) run(interval time.Duration) {
+	for {
+		for _, file := range watchedFiles(m.files) {
+			m.check(file)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// check re-reads the certificate in file, warning and running the
+// renewal hook if it has crossed a new threshold.
+func (m *certMonitor) check(file string) {
+	c, ok := readCert(file)
+	m.mu.Lock()
+	if !ok {
+		delete(m.certs, file)
+		m.mu.Unlock()
+		return
+	}
+	m.certs[file] = c
+
+	crossed := -1
+	for i, days := range m.thresholds {
+		if c.DaysLeft <= days {
+			crossed = i
+		}
+	}
+	last, warned := m.warned[file]
+	if crossed < 0 {
+		// renewed, or never close to expiring
+		delete(m.warned, file)
+	} else if !warned || crossed > last {
+		m.warned[file] = crossed
+	}
+	m.mu.Unlock()
+
+	if crossed < 0 || (warned && crossed <= last) {
+		return
+	}
+	log.Printf("==> warning: certificate %s (%s) expires in %d days", file, c.Subject, c.DaysLeft)
+	if *certRenewFlag == "" {
+		return
+	}
+
+	log.Println("==> running certificate renewal for", file)
+	cmd := shellCommand(*certRenewFlag)
+	cmd.Env = append(os.Environ(), "BLART_CERT="+file)
+	setGroup(cmd)
+	err := startRedacted(cmd)
+	if err == nil {
+		err = waitTimeout(cmd, *certRenewTimeoutFlag)
+	}
+	if err != nil {
+		log.Println("==> error: certificate renewal failed:", err)
+	}
+	// pick up the renewed certificate, resetting the warnings if
+	// it's no longer close to expiring
+	m.mu.Lock()
+	c, ok = readCert(file)
+	if ok {
+		m.certs[file] = c
+		if len(m.thresholds) == 0 || c.DaysLeft > m.thresholds[0] {
+			delete(m.warned, file)
+			log.Printf("==> certificate %s renewed, expires in %d days", file, c.DaysLeft)
+		}
+	}
+	m.mu.Unlock()
+}
+
+func (m *certMonitor) status() []certStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	certs := make([]certStatus, 0, len(m.certs))
+	for _, c := range m.certs {
+		certs = append(certs, c)
+	}
+	sort.Sort(byFile(certs))
+	return certs
+}
+
+type byFile []certStatus
+
+func (s byFile) Len() int           { return len(s) }
+func (s byFile) Less(i, j int) bool { return s[i].File < s[j].File }
+func (s byFile) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	chaosFlag        = flag.Bool("chaos", false, "randomly signal, and kill and restart the child, for resilience testing")
+	chaosMinFlag     = flag.Duration("chaos-min", 10*time.Second, "minimum time between injected faults")
+	chaosMaxFlag     = flag.Duration("chaos-max", time.Minute, "maximum time between injected faults")
+	chaosSignalsFlag = flag.String("chaos-signals", "", "signals that may be sent at random, split by ':'")
+	chaosSeedFlag    = flag.Int64("chaos-seed", 0, "seed for reproducing a chaos run (default random)")
+)
+
+type chaos struct {
+	seed    int64
+	min     time.Duration
+	max     time.Duration
+	signals []os.Signal
+	names   []string
+}
+
+func newChaos() (*chaos, error) {
+	c := &chaos{
+		seed: *chaosSeedFlag,
+		min:  *chaosMinFlag,
+		max:  *chaosMaxFlag,
+	}
+	if c.seed == 0 {
+		c.seed = time.Now().UnixNano()
+	}
+	if c.min <= 0 || c.max < c.min {
+		return nil, fmt.Errorf("invalid chaos interval: %s-%s", c.min, c.max)
+	}
+	if *chaosSignalsFlag != "" {
+		for _, name := range strings.Split(*chaosSignalsFlag, ":") {
+			sig, err := signalByName(name)
+			if err != nil {
+				return nil, err
+			}
+			c.signals = append(c.signals, sig)
+			c.names = append(c.names, strings.ToUpper(name))
+		}
+	}
+	return c, nil
+}
+
+// run injects faults into child forever. Given the same seed, the
+// same faults are injected at the same intervals.
+func (c *chaos) run(child *child, reload os.Signal) {
+	r := rand.New(rand.NewSource(c.seed))
+	log.Printf("==> chaos: enabled with seed %d", c.seed)
+
+	for {
+		time.Sleep(c.min + time.Duration(r.Int63n(int64(c.max-c.min)+1)))
+
+		faults := 2
+		if len(c.signals) > 0 {
+			faults++
+		}
+
+		switch r.Intn(faults) {
+		case 0:
+			log.Printf("==> chaos: sending reload signal (seed %d)", c.seed)
+			child.signal(reload)
+		case 1:
+			log.Printf("==> chaos: killing and restarting child (seed %d)", c.seed)
+			if err := child.restart(os.Kill, *stopTimeoutFlag); err != nil {
+				log.Println("==> error:", err)
+			}
+		case 2:
+			i := r.Intn(len(c.signals))
+			log.Printf("==> chaos: sending %s (seed %d)", c.names[i], c.seed)
+			child.signal(c.signals[i])
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// child wraps the command being supervised so that it can be
+// signalled and restarted in place without blart itself exiting.
+type child struct {
+	args []string
+	// output, if set, receives both stdout and stderr
+	output io.Writer
+
+	mu   sync.Mutex
+	env  []string
+	cmd  *exec.Cmd
+	done chan struct{}
+
+	// exited receives when the running process exits on its own,
+	// as opposed to being stopped as part of a restart.
+	exited chan struct{}
+}
+
+func newChild(args []string, env []string) *child {
+	return &child{
+		args:   args,
+		env:    env,
+		exited: make(chan struct{}, 1),
+	}
+}
+
+func (c *child) start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.startLocked()
+}
+
+func (c *child) startLocked() error {
+	cmd := exec.Command(c.args[0], c.args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if c.output != nil {
+		cmd.Stdout = c.output
+		cmd.Stderr = c.output
+	}
+	cmd.Env = c.env
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	c.cmd = cmd
+	c.done = done
+
+	go func() {
+		cmd.Wait()
+		close(done)
+
+		c.mu.Lock()
+		current := c.cmd == cmd
+		c.mu.Unlock()
+		// a process replaced by a restart isn't an exit of the child
+		if current {
+			c.exited <- struct{}{}
+		}
+	}()
+	return nil
+}
+
+// wait returns a channel that is closed once the currently
+// running process exits.
+func (c *child) wait() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done
+}
+
+func (c *child) pid() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cmd == nil {
+		return 0
+	}
+	return c.cmd.Process.Pid
+}
+
+func (c *child) running() bool {
+	select {
+	case <-c.wait():
+		return false
+	default:
+		return true
+	}
+}
+
+func (c *child) signal(sig os.Signal) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cmd == nil {
+		return errors.New("child not started")
+	}
+	return c.cmd.Process.Signal(sig)
+}
+
+func (c *child) setEnv(env []string) {
+	c.mu.Lock()
+	c.env = env
+	c.mu.Unlock()
+}
+
+// stop stops the running process with sig, killing it if it hasn't
+// exited within timeout. A stopped child doesn't count as exited.
+func (c *child) stop(sig os.Signal, timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopLocked(sig, timeout)
+	c.cmd = nil
+}
+
+func (c *child) stopLocked(sig os.Signal, timeout time.Duration) {
+	if c.cmd == nil {
+		return
+	}
+	c.cmd.Process.Signal(sig)
+	select {
+	case <-c.done:
+	case <-time.After(timeout):
+		log.Printf("==> child didn't exit after %s, killing", timeout)
+		c.cmd.Process.Signal(os.Kill)
+		<-c.done
+	}
+}
+
+// restart stops the running process, as with stop, then starts it
+// again.
+func (c *child) restart(sig os.Signal, timeout time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopLocked(sig, timeout)
+	return c.startLocked()
+}
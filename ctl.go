@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ctl implements `blart ctl <command>`, which talks to a running
+// blart over the API served on -control.
+func ctl(args []string) {
+	if len(args) != 1 {
+		usageAndExit("usage: blart -control <addr> ctl status|approve|reject, or blart -pidfile <file> ctl stop")
+	}
+	if args[0] == "stop" {
+		if *pidFileFlag == "" {
+			usageAndExit("-pidfile is required for ctl stop")
+		}
+		// allow for the child's stop sequence, and then some
+		if err := stopDaemon(*pidFileFlag, *stopTimeoutFlag+5*time.Second); err != nil {
+			fmt.Printf("!! %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("ok")
+		return
+	}
+
+	if *controlFlag == "" {
+		usageAndExit("-control is required for ctl")
+	}
+	url := "http://" + controlAddr(*controlFlag) + "/" + args[0]
+
+	var resp *http.Response
+	var err error
+	switch args[0] {
+	case "status":
+		resp, err = http.Get(url)
+	case "approve", "reject":
+		resp, err = http.Post(url, "text/plain", nil)
+	default:
+		usageAndExit(fmt.Sprintf("unknown ctl command: %s", args[0]))
+	}
+	if err != nil {
+		fmt.Printf("!! %s\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	io.Copy(os.Stdout, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	daemonFlag       = flag.Bool("daemon", false, "detach into the background, writing output to -log-file")
+	logFileFlag      = flag.String("log-file", "", "file blart's output is appended to with -daemon")
+	childLogFileFlag = flag.String("child-log-file", "", "file the child's output is appended to (default blart's output)")
+	pidFileFlag      = flag.String("pidfile", "", "file to write blart's pid to, used by 'blart ctl stop'")
+)
+
+// daemonEnv is set in the environment of the detached process, so it
+// knows not to detach again.
+const daemonEnv = "BLART_DAEMONIZED"
+
+func daemonized() bool {
+	if os.Getenv(daemonEnv) == "" {
+		return false
+	}
+	// don't leak it to the child
+	os.Unsetenv(daemonEnv)
+	openReadyPipe()
+	return true
+}
+
+func openLog(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+}
+
+// pidFileWritten is whether this blart wrote -pidfile, and so may
+// remove it.
+var pidFileWritten bool
+
+// writePidFile writes blart's pid to path, refusing to if path names
+// a process that's still running, most likely another blart that
+// would otherwise lose it.
+func writePidFile(path string) error {
+	if b, err := ioutil.ReadFile(path); err == nil {
+		pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err == nil && pid != os.Getpid() && processAlive(pid) {
+			return fmt.Errorf("pidfile %s belongs to pid %d, which is still running", path, pid)
+		}
+	}
+	err := ioutil.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+	pidFileWritten = err == nil
+	return err
+}
+
+// removePidFile removes -pidfile if this blart wrote it. Every exit
+// after writing it must call this, or 'blart ctl stop' could later
+// signal an unrelated process that has reused the pid.
+func removePidFile() {
+	if pidFileWritten {
+		os.Remove(*pidFileFlag)
+		pidFileWritten = false
+	}
+}
+
+// stopDaemon signals the blart whose pid is in path to shut down,
+// and waits for it to exit.
+func stopDaemon(path string, timeout time.Duration) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return fmt.Errorf("invalid pidfile %s", path)
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		// signal 0 only checks that the process still exists
+		if p.Signal(syscall.Signal(0)) != nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return errors.New("timed out waiting for blart to stop")
+}
@@ -0,0 +1,104 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// readyFd is where the detached process finds the pipe it reports a
+// successful start on.
+const readyFd = 3
+
+var readyPipe *os.File
+
+// daemonize starts blart again, detached in a new session with its
+// output going to logFile, and waits for it to either finish starting
+// up or fail. The caller should then exit.
+func daemonize(logFile string) error {
+	out, err := openLog(logFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	devnull, err := os.Open(os.DevNull)
+	if err != nil {
+		return err
+	}
+	defer devnull.Close()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	exe, err := executable()
+	if err != nil {
+		w.Close()
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.Stdin = devnull
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.ExtraFiles = []*os.File{w}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+
+	// the pipe is closed without a byte written if it exits first
+	b := make([]byte, 1)
+	if n, _ := r.Read(b); n != 1 {
+		return fmt.Errorf("blart failed to start in the background, see %s", logFile)
+	}
+	fmt.Printf("==> daemonized as pid %d\n", cmd.Process.Pid)
+	return nil
+}
+
+func openReadyPipe() {
+	// nothing blart starts should hold it open
+	syscall.CloseOnExec(readyFd)
+	readyPipe = os.NewFile(readyFd, "ready")
+}
+
+// daemonReady tells the process that detached this one that it has
+// started successfully.
+func daemonReady() {
+	if readyPipe == nil {
+		return
+	}
+	readyPipe.Write([]byte{1})
+	readyPipe.Close()
+	readyPipe = nil
+}
+
+// executable finds blart's own binary, as os.Executable isn't
+// available in the Go this is built with.
+func executable() (string, error) {
+	if exe, err := os.Readlink("/proc/self/exe"); err == nil {
+		return exe, nil
+	}
+	path, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(path)
+}
+
+// processAlive reports whether pid is a running process.
+func processAlive(pid int) bool {
+	// signal 0 only checks that the process exists
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
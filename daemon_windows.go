@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func daemonize(logFile string) error {
+	return errors.New("-daemon is not supported on windows")
+}
+
+func openReadyPipe() {}
+
+func daemonReady() {}
+
+// processAlive reports whether pid is a running process, which on
+// windows is whether it can be opened.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
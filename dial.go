@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	dialFlag          = flag.String("dial", "", "host:port targets whose reachability counts as a change when it flips, split by ','")
+	dialIntervalFlag  = flag.Duration("dial-interval", 5*time.Second, "time between reachability checks of -dial targets")
+	dialThresholdFlag = flag.Int("dial-threshold", 3, "consecutive results needed before reachability is considered flipped")
+)
+
+func dialTargets() []string {
+	var targets []string
+	for _, t := range strings.Split(*dialFlag, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
+
+// watchReachability dials target every interval and calls flipped
+// once threshold consecutive results disagree with its last known
+// reachability. The first settled result only establishes it. It
+// returns once stop is closed.
+func watchReachability(target string, interval time.Duration, threshold int, flipped func(target string, up bool), stop <-chan struct{}) {
+	var (
+		known   bool
+		settled bool
+		last    bool
+		streak  int
+	)
+	for {
+		conn, err := net.DialTimeout("tcp", target, interval)
+		up := err == nil
+		if up {
+			conn.Close()
+		}
+
+		if up == last {
+			streak++
+		} else {
+			last, streak = up, 1
+		}
+		if streak >= threshold && (!settled || up != known) {
+			if settled {
+				flipped(target, up)
+			}
+			known, settled = up, true
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWatchReachability(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	accept := func(ln net.Listener) {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}
+	go accept(ln)
+
+	const (
+		interval  = 20 * time.Millisecond
+		threshold = 5
+	)
+	flips := make(chan bool, 10)
+	stop := make(chan struct{})
+	defer close(stop)
+	go watchReachability(addr, interval, threshold, func(target string, up bool) {
+		if target != addr {
+			t.Errorf("flipped target = %s, want %s", target, addr)
+		}
+		flips <- up
+	}, stop)
+
+	expectNone := func(d time.Duration) {
+		select {
+		case up := <-flips:
+			t.Fatalf("unexpected flip, up = %v", up)
+		case <-time.After(d):
+		}
+	}
+	expect := func(want bool) {
+		select {
+		case up := <-flips:
+			if up != want {
+				t.Fatalf("flipped up = %v, want %v", up, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no flip, want up = %v", want)
+		}
+	}
+	reopen := func() {
+		var err error
+		ln, err = net.Listen("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go accept(ln)
+	}
+
+	// settling on the first reachability isn't a flip
+	expectNone(2 * threshold * interval)
+
+	// an outage shorter than the threshold is ignored
+	ln.Close()
+	time.Sleep(interval)
+	reopen()
+	expectNone(2 * threshold * interval)
+
+	ln.Close()
+	expect(false)
+	expectNone(2 * threshold * interval)
+
+	reopen()
+	expect(true)
+	ln.Close()
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxSnapshotSize is the largest file whose contents are kept for
+// diffing. Anything larger is only reported as changed.
+const maxSnapshotSize = 1 << 20
+
+// snapshot holds the contents of watched files, keyed by path.
+type snapshot map[string]string
+
+// watchedFiles expands paths to the regular files they cover: the
+// paths themselves, and the files directly inside any directories,
+// matching how they are watched.
+func watchedFiles(paths []string) []string {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, path)
+			continue
+		}
+		if !info.IsDir() {
+			continue
+		}
+		infos, err := ioutil.ReadDir(path)
+		if err != nil {
+			continue
+		}
+		for _, info := range infos {
+			if info.Mode().IsRegular() {
+				files = append(files, filepath.Join(path, info.Name()))
+			}
+		}
+	}
+	return files
+}
+
+// takeSnapshot reads the files covered by paths.
+func takeSnapshot(paths []string) snapshot {
+	snap := make(snapshot)
+	for _, path := range watchedFiles(paths) {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.Size() > maxSnapshotSize {
+			snap[path] = ""
+			continue
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		snap[path] = string(b)
+	}
+	return snap
+}
+
+// fileDiff is the difference in a single file between two snapshots.
+type fileDiff struct {
+	File string `json:"file"`
+	Diff string `json:"diff"`
+}
+
+// diffSnapshots compares two snapshots. Contents of hidden files are
+// never included, and secrets are redacted from the rest.
+func diffSnapshots(old, new snapshot) []fileDiff {
+	paths := make([]string, 0, len(new))
+	for path := range new {
+		paths = append(paths, path)
+	}
+	for path := range old {
+		if _, ok := new[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var diffs []fileDiff
+	for _, path := range paths {
+		after, ok := new[path]
+		if !ok {
+			diffs = append(diffs, fileDiff{File: path, Diff: "(removed)"})
+			continue
+		}
+		before, ok := old[path]
+		switch {
+		case !ok:
+			before = ""
+		case before == after:
+			continue
+		}
+		d := fileDiff{File: path}
+		if redaction.hidden(path) {
+			d.Diff = "(contents hidden)"
+		} else {
+			d.Diff = redaction.String(diffLines(before, after))
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs
+}
+
+// diffLines returns a minimal line diff of a and b, with removed lines
+// prefixed by '-' and added lines by '+'.
+func diffLines(a, b string) string {
+	x := strings.SplitAfter(a, "\n")
+	y := strings.SplitAfter(b, "\n")
+	if len(x)*len(y) > 1e7 {
+		return "(too large to diff)"
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence
+	// of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out []string
+	line := func(prefix, s string) {
+		if s != "" {
+			out = append(out, prefix+strings.TrimSuffix(s, "\n"))
+		}
+	}
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			line("-", x[i])
+			i++
+		default:
+			line("+", y[j])
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		line("-", x[i])
+	}
+	for ; j < len(y); j++ {
+		line("+", y[j])
+	}
+	return strings.Join(out, "\n")
+}
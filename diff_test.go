@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestDiffLines(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want string
+	}{
+		{"a\nb\n", "a\nb\n", ""},
+		{"a\n", "b\n", "-a\n+b"},
+		{"a\nb\nc\n", "a\nc\n", "-b"},
+		{"a\nc\n", "a\nb\nc\n", "+b"},
+		{"", "a\nb\n", "+a\n+b"},
+		{"a\nb\n", "", "-a\n-b"},
+		{"a\nb\n", "b\na\n", "-a\n+a"},
+		// a missing trailing newline changes the last line
+		{"a", "a\n", "-a\n+a"},
+	} {
+		if got := diffLines(tt.a, tt.b); got != tt.want {
+			t.Errorf("diffLines(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	confirmFlag         = flag.String("confirm", "", "shell command that must succeed after a reload for it to count as applied")
+	confirmTimeoutFlag  = flag.Duration("confirm-timeout", 10*time.Second, "time -confirm may run, retrying failures, before it's killed and the reload counts as failed")
+	fallbackRestartFlag = flag.Bool("fallback-restart", false, "restart the child when a reload isn't confirmed")
+	maxRestartsFlag     = flag.Int("max-restarts", 3, "most fallback restarts allowed within -restart-window")
+	restartWindowFlag   = flag.Duration("restart-window", 10*time.Minute, "window -max-restarts applies to")
+)
+
+// confirmReload runs the -confirm command until it succeeds, or
+// until timeout has elapsed, killing an attempt still running then.
+func confirmReload(timeout time.Duration) error {
+	if *confirmFlag == "" {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		cmd := shellCommand(*confirmFlag)
+		cmd.Env = os.Environ()
+		setGroup(cmd)
+		err := cmd.Start()
+		if err == nil {
+			err = waitTimeout(cmd, deadline.Sub(time.Now()))
+		}
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(timeoutError); ok {
+			return fmt.Errorf("reload not confirmed after %s, -confirm was still running", timeout)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("reload not confirmed after %s: %s", timeout, err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// restartLimit bounds how many restarts may happen in a sliding
+// window of time.
+type restartLimit struct {
+	max    int
+	window time.Duration
+
+	mu    sync.Mutex
+	times []time.Time
+}
+
+// allow records a restart and reports whether it's within the limit.
+func (r *restartLimit) allow() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cutoff := time.Now().Add(-r.window)
+	recent := r.times[:0]
+	for _, t := range r.times {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	r.times = recent
+
+	if len(r.times) >= r.max {
+		return false
+	}
+	r.times = append(r.times, time.Now())
+	return true
+}
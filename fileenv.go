@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+var fileEnvFlag = flag.Bool("file-env", false, "resolve *_FILE environment variables for the child, restarting it when the files change")
+
+// fileEnv resolves the *_FILE convention used by Docker and Kubernetes
+// secrets: for every FOO_FILE=path, the contents of path are passed to
+// the child as FOO. The returned map is of file path to variable name.
+// Values are never logged.
+func fileEnv(environ []string) ([]string, map[string]string, error) {
+	env := make([]string, 0, len(environ))
+	files := make(map[string]string)
+	set := make(map[string]bool)
+
+	for _, kv := range environ {
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			continue
+		}
+		set[kv[:i]] = true
+	}
+
+	for _, kv := range environ {
+		env = append(env, kv)
+		i := strings.Index(kv, "=")
+		if i < 0 || !strings.HasSuffix(kv[:i], "_FILE") {
+			continue
+		}
+		name, path := strings.TrimSuffix(kv[:i], "_FILE"), kv[i+1:]
+		if name == "" || path == "" {
+			continue
+		}
+		if set[name] {
+			return nil, nil, fmt.Errorf("both %s and %s_FILE are set", name, name)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s_FILE: %s", name, err)
+		}
+		// match `$(< file)` in shell entrypoints, which drops the
+		// trailing newline most secrets are written with
+		value := strings.TrimRight(string(b), "\r\n")
+		env = append(env, name+"="+value)
+		files[path] = name
+	}
+
+	return env, files, nil
+}
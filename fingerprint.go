@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+// fingerprint identifies the version of the config the child is
+// running, as a hash over the contents of the watched files and
+// promoted configs. It's published in logs, metrics and the status,
+// so files blart writes itself, and files whose contents are hidden,
+// which would make a short secret easy to brute force, are left out.
+type fingerprint struct {
+	files []string
+
+	mu      sync.Mutex
+	current string
+}
+
+func newFingerprint(files []string) *fingerprint {
+	f := &fingerprint{files: files}
+	f.update()
+	return f
+}
+
+// update recomputes the fingerprint, logging it if it changed.
+func (f *fingerprint) update() string {
+	sum := f.compute()
+	f.set(sum)
+	return sum
+}
+
+// compute hashes the files as they are now, without making it the
+// current fingerprint.
+func (f *fingerprint) compute() string {
+	paths := watchedFiles(f.files)
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, path := range paths {
+		if selfWrites.owned(path) || redaction.hidden(path) {
+			continue
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		io.WriteString(h, path+"\x00")
+		io.Copy(h, file)
+		io.WriteString(h, "\x00")
+		file.Close()
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// set makes sum, from compute, the current fingerprint once the child
+// is running the config it was computed from.
+func (f *fingerprint) set(sum string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if sum != f.current {
+		log.Println("==> config fingerprint", sum)
+		f.current = sum
+	}
+}
+
+func (f *fingerprint) get() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.current
+}
@@ -0,0 +1,9 @@
+package main
+
+import "os/exec"
+
+func setGroup(cmd *exec.Cmd) {}
+
+func killGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	httpFlag              = flag.String("http", "", "address to serve health checks and metrics on, e.g. ':8080'")
+	readyCheckFlag        = flag.String("ready-check", "", "shell command that must succeed for the child to be reported ready")
+	readyCheckTimeoutFlag = flag.Duration("ready-check-timeout", 5*time.Second, "time -ready-check may run before the child counts as not ready")
+)
+
+// health tracks the state of reloads so that orchestrator probes
+// can take the child out of rotation while one is in flight, or
+// after one has failed.
+type health struct {
+	mu sync.Mutex
+	// reloads that have been started but not yet finished or held,
+	// across all of the signal, restart and promotion pipelines
+	inFlight int
+	lastErr  error
+	// unready is why the child isn't ready regardless of reloads,
+	// if it isn't
+	unready string
+}
+
+// reloadStarted marks a reload as in progress. Each call must be
+// matched by one to reloadFinished or reloadHeld.
+func (h *health) reloadStarted() {
+	h.mu.Lock()
+	h.inFlight++
+	h.mu.Unlock()
+}
+
+func (h *health) reloadFinished(err error) {
+	h.mu.Lock()
+	h.done()
+	h.lastErr = err
+	h.mu.Unlock()
+}
+
+// reloadHeld marks a reload as no longer in progress without it
+// having happened, e.g. while it waits for approval, or because it
+// was folded into one that was already pending.
+func (h *health) reloadHeld() {
+	h.mu.Lock()
+	h.done()
+	h.mu.Unlock()
+}
+
+// setUnready marks the child as not ready for reason until it's
+// called again with "".
+func (h *health) setUnready(reason string) {
+	h.mu.Lock()
+	h.unready = reason
+	h.mu.Unlock()
+}
+
+func (h *health) done() {
+	if h.inFlight > 0 {
+		h.inFlight--
+	}
+}
+
+func (h *health) ready() (bool, string) {
+	h.mu.Lock()
+	inFlight, lastErr, unready := h.inFlight, h.lastErr, h.unready
+	h.mu.Unlock()
+	switch {
+	case unready != "":
+		return false, unready
+	case inFlight > 0:
+		return false, "reload in progress"
+	case lastErr != nil:
+		return false, fmt.Sprintf("last reload failed: %s", lastErr)
+	}
+	if err := readyCheck(); err != nil {
+		return false, fmt.Sprintf("ready check failed: %s", err)
+	}
+	return true, "ok"
+}
+
+// readyCheck runs the -ready-check command, which asks the child
+// itself whether it's ready.
+func readyCheck() error {
+	if *readyCheckFlag == "" {
+		return nil
+	}
+	cmd := shellCommand(*readyCheckFlag)
+	setGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return waitTimeout(cmd, *readyCheckTimeoutFlag)
+}
+
+func handleHealth(mux *http.ServeMux, child *child, h *health) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if !child.running() {
+			http.Error(w, "child not running", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !child.running() {
+			http.Error(w, "child not running", http.StatusServiceUnavailable)
+			return
+		}
+		if ok, reason := h.ready(); !ok {
+			http.Error(w, reason, http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
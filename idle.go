@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+)
+
+var idleWaitFlag = flag.Duration("idle-wait", 0, "maximum time to wait for the child to have no established connections before restarting it")
+
+// waitIdle blocks until the child has no established TCP connections
+// on the ports it's listening on, or until max has elapsed.
+func waitIdle(child *child, max time.Duration) {
+	start := time.Now()
+	deadline := start.Add(max)
+	for {
+		n, err := connections(child.pid())
+		if err != nil {
+			log.Println("==> error:", err)
+			return
+		}
+		if n == 0 {
+			log.Printf("==> child idle after %s", time.Since(start))
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Printf("==> child still has %d connections after %s, restarting anyway", n, time.Since(start))
+			return
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
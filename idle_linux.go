@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	tcpEstablished = "01"
+	tcpListen      = "0A"
+)
+
+// connections counts the established TCP connections on ports the
+// process or anything it started is listening on, as seen in
+// /proc/<pid>/net/tcp{,6}. The whole tree is looked at, as the child
+// may be a wrapper like a shell or an init that isn't the server.
+func connections(pid int) (int, error) {
+	tree, err := processTree(pid)
+	if err != nil {
+		return 0, err
+	}
+	inodes := make(map[string]bool)
+	var collect func(p *proc)
+	collect = func(p *proc) {
+		fds, _ := filepath.Glob(fmt.Sprintf("/proc/%d/fd/*", p.Pid))
+		for _, fd := range fds {
+			link, err := os.Readlink(fd)
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inodes[link[len("socket:["):len(link)-1]] = true
+		}
+		for _, c := range p.Children {
+			collect(c)
+		}
+	}
+	collect(tree)
+
+	var sockets [][]string
+	for _, name := range []string{"tcp", "tcp6"} {
+		f, err := os.Open(fmt.Sprintf("/proc/%d/net/%s", pid, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Scan() // header
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 10 {
+				sockets = append(sockets, fields)
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	// fields are: sl local_address rem_address st ... inode
+	port := func(addr string) string {
+		return addr[strings.LastIndex(addr, ":")+1:]
+	}
+	listening := make(map[string]bool)
+	for _, s := range sockets {
+		if s[3] == tcpListen && inodes[s[9]] {
+			listening[port(s[1])] = true
+		}
+	}
+	n := 0
+	for _, s := range sockets {
+		if s[3] == tcpEstablished && listening[port(s[1])] {
+			n++
+		}
+	}
+	return n, nil
+}
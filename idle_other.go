@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func connections(pid int) (int, error) {
+	return 0, errors.New("waiting for the child to be idle is only supported on linux")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stringList is a flag that may be given more than once.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ", ") }
+func (l *stringList) Set(s string) error { *l = append(*l, s); return nil }
+
+func stringListFlag(name, usage string) *stringList {
+	l := new(stringList)
+	flag.Var(l, name, usage)
+	return l
+}
+
+var (
+	initFlag            = stringListFlag("init", "shell command to run before the child starts, may be given more than once")
+	initTimeoutFlag     = flag.Duration("init-timeout", time.Minute, "time an init step may run before it's killed")
+	initRetriesFlag     = flag.Int("init-retries", 0, "times to retry a failing init step")
+	initEachRestartFlag = flag.Bool("init-each-restart", false, "run the init steps again before each restart of the child")
+)
+
+// runInit runs each init step in order, retrying failures, and stops
+// at the first one that still fails.
+func runInit(steps []string) error {
+	for _, step := range steps {
+		var err error
+		for attempt := 0; attempt <= *initRetriesFlag; attempt++ {
+			if attempt > 0 {
+				log.Printf("==> retrying init step (attempt %d): %s", attempt+1, step)
+			} else {
+				log.Println("==> running init step:", step)
+			}
+			if err = runStep(step, *initTimeoutFlag); err == nil {
+				break
+			}
+			log.Println("==> error:", err)
+		}
+		if err != nil {
+			return fmt.Errorf("init step failed: %s", step)
+		}
+	}
+	return nil
+}
+
+// initStep is the init step running, if any, so that it can be
+// killed when blart is told to shut down.
+var initStep struct {
+	sync.Mutex
+	cmd *exec.Cmd
+}
+
+func runStep(step string, timeout time.Duration) error {
+	cmd := shellCommand(step)
+	setGroup(cmd)
+	initStep.Lock()
+	err := startRedacted(cmd)
+	if err == nil {
+		initStep.cmd = cmd
+	}
+	initStep.Unlock()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		initStep.Lock()
+		initStep.cmd = nil
+		initStep.Unlock()
+	}()
+	return waitTimeout(cmd, timeout)
+}
+
+// killInit kills the init step running, if any, and everything it
+// started, which is in a process group of its own and so isn't sent
+// the signals blart is.
+func killInit() {
+	initStep.Lock()
+	defer initStep.Unlock()
+	if initStep.cmd != nil {
+		killGroup(initStep.cmd)
+	}
+}
+
+// waitTimeout waits for cmd, which was started with setGroup, killing
+// it and everything it started if it runs longer than timeout.
+func waitTimeout(cmd *exec.Cmd, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		// it's usually a shell, so kill whatever it started too
+		killGroup(cmd)
+		<-done
+		return timeoutError(timeout)
+	}
+}
+
+// timeoutError is returned by waitTimeout when it killed the command.
+type timeoutError time.Duration
+
+func (e timeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", time.Duration(e))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	jitterFlag     = flag.Duration("jitter", 0, "spread over which to randomly delay acting on a change, so replicas don't all reload at once")
+	jitterHostFlag = flag.Bool("jitter-host", false, "derive the -jitter delay from the hostname, so it's the same on every change")
+)
+
+// jitterRand is seeded per process, so that replicas started at the
+// same time still pick different delays.
+var (
+	jitterRand   = rand.New(rand.NewSource(time.Now().UnixNano() ^ int64(os.Getpid())))
+	jitterRandMu sync.Mutex
+)
+
+// jitterDelay picks a delay within spread, either at random, or fixed
+// per host by hashing the hostname.
+func jitterDelay(spread time.Duration, byHost bool) time.Duration {
+	if spread <= 0 {
+		return 0
+	}
+	if byHost {
+		if hostname, err := os.Hostname(); err == nil {
+			h := fnv.New64a()
+			h.Write([]byte(hostname))
+			return time.Duration(h.Sum64() % uint64(spread))
+		}
+	}
+	jitterRandMu.Lock()
+	defer jitterRandMu.Unlock()
+	return time.Duration(jitterRand.Int63n(int64(spread)))
+}
+
+// withJitter wraps fn to sleep for a jittered delay before running.
+func withJitter(fn func()) func() {
+	return func() {
+		delay := jitterDelay(*jitterFlag, *jitterHostFlag)
+		log.Printf("==> delaying by %s (jitter)", delay)
+		time.Sleep(delay)
+		fn()
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var redactKeysFlag = flag.String("redact-keys", "", "key selectors whose values are redacted from everything blart outputs, split by ','")
+
+// keyWatch scopes a watched JSON or YAML file down to a set of key
+// selectors, e.g. `settings.yaml#$.database.host,$.cache`, so that
+// only changes to those keys count as a change to the file.
+type keyWatch struct {
+	file      string
+	selectors []string
+	values    map[string]string
+}
+
+// keyChange is a single selector whose value changed.
+type keyChange struct {
+	selector string
+	old, new string
+}
+
+func (c keyChange) String() string {
+	return fmt.Sprintf("%s changed: %s -> %s", c.selector, c.old, c.new)
+}
+
+// parseWatch splits a -f entry into the file and its key selectors.
+func parseWatch(s string) (file string, selectors []string) {
+	i := strings.Index(s, "#")
+	if i < 0 {
+		return s, nil
+	}
+	for _, sel := range strings.Split(s[i+1:], ",") {
+		if sel != "" {
+			selectors = append(selectors, sel)
+		}
+	}
+	return s[:i], selectors
+}
+
+func newKeyWatch(file string, selectors []string) (*keyWatch, error) {
+	k := &keyWatch{file: file, selectors: selectors}
+	values, err := k.read()
+	if err != nil {
+		return nil, err
+	}
+	k.values = values
+	return k, nil
+}
+
+func (k *keyWatch) read() (map[string]string, error) {
+	b, err := ioutil.ReadFile(k.file)
+	if err != nil {
+		return nil, err
+	}
+	// YAML is a superset of JSON, so this covers both
+	var doc interface{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %s", k.file, err)
+	}
+
+	values := make(map[string]string, len(k.selectors))
+	for _, sel := range k.selectors {
+		v, err := selectKey(doc, sel)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", k.file, err)
+		}
+		if v == nil {
+			values[sel] = "<missing>"
+			continue
+		}
+		out, err := yaml.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		values[sel] = strings.TrimSpace(string(out))
+		if redactedKey(sel) {
+			redaction.addValue(values[sel])
+		}
+	}
+	return values, nil
+}
+
+// changes re-reads the file and returns the selectors whose values
+// have changed since it was last read.
+func (k *keyWatch) changes() ([]keyChange, error) {
+	values, err := k.read()
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []keyChange
+	for _, sel := range k.selectors {
+		if values[sel] == k.values[sel] {
+			continue
+		}
+		c := keyChange{selector: sel, old: k.values[sel], new: values[sel]}
+		if redactedKey(sel) {
+			c.old, c.new = "[REDACTED]", "[REDACTED]"
+		}
+		changes = append(changes, c)
+	}
+	k.values = values
+	return changes, nil
+}
+
+// selectKey walks doc following a JSONPath/YAML-path style selector,
+// such as `$.servers[0].host`. A nil result means the key is missing.
+func selectKey(doc interface{}, sel string) (interface{}, error) {
+	path, err := splitSelector(sel)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range path {
+		switch node := doc.(type) {
+		case map[interface{}]interface{}:
+			doc = node[p]
+		case []interface{}:
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, nil
+			}
+			doc = node[i]
+		default:
+			return nil, nil
+		}
+	}
+	return doc, nil
+}
+
+func splitSelector(sel string) ([]string, error) {
+	s := strings.TrimPrefix(sel, "$")
+	var path []string
+	for s != "" {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			i := strings.IndexAny(s, ".[")
+			if i < 0 {
+				i = len(s)
+			}
+			if i == 0 {
+				return nil, fmt.Errorf("invalid selector: %s", sel)
+			}
+			path = append(path, s[:i])
+			s = s[i:]
+		case '[':
+			i := strings.Index(s, "]")
+			if i < 0 {
+				return nil, fmt.Errorf("invalid selector: %s", sel)
+			}
+			path = append(path, strings.Trim(s[1:i], `'"`))
+			s = s[i+1:]
+		default:
+			// allow a bare leading key, as in `database.host`
+			if len(path) > 0 {
+				return nil, fmt.Errorf("invalid selector: %s", sel)
+			}
+			s = "." + s
+		}
+	}
+	return path, nil
+}
+
+// redactedKey reports whether the value selected by sel may contain
+// a key listed in -redact-keys.
+func redactedKey(sel string) bool {
+	if *redactKeysFlag == "" {
+		return false
+	}
+	path, err := splitSelector(sel)
+	if err != nil {
+		return true
+	}
+	for _, r := range strings.Split(*redactKeysFlag, ",") {
+		rpath, err := splitSelector(r)
+		if err != nil || len(rpath) == 0 {
+			continue
+		}
+		// either selector being a prefix of the other means they overlap
+		n := len(path)
+		if len(rpath) < n {
+			n = len(rpath)
+		}
+		overlap := true
+		for i := 0; i < n; i++ {
+			if path[i] != rpath[i] {
+				overlap = false
+				break
+			}
+		}
+		if overlap {
+			return true
+		}
+	}
+	return false
+}
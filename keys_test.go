@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestSplitSelector(t *testing.T) {
+	for _, tt := range []struct {
+		sel  string
+		want []string
+		err  bool
+	}{
+		{sel: "$.db.host", want: []string{"db", "host"}},
+		{sel: "db.host", want: []string{"db", "host"}},
+		{sel: "$.servers[0].host", want: []string{"servers", "0", "host"}},
+		{sel: "$['a.b'].c", want: []string{"a.b", "c"}},
+		{sel: `$["a"]`, want: []string{"a"}},
+		{sel: "$", want: nil},
+		{sel: "$.db..host", err: true},
+		{sel: "$.db.", err: true},
+		{sel: "$.servers[0", err: true},
+		{sel: "$.servers[0]host", err: true},
+	} {
+		got, err := splitSelector(tt.sel)
+		if tt.err {
+			if err == nil {
+				t.Errorf("splitSelector(%q) = %q, want an error", tt.sel, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitSelector(%q): %s", tt.sel, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitSelector(%q) = %q, want %q", tt.sel, got, tt.want)
+		}
+	}
+}
+
+func TestSelectKey(t *testing.T) {
+	var doc interface{}
+	err := yaml.Unmarshal([]byte(`
+db:
+  host: db.internal
+  port: 5432
+servers:
+  - name: a
+  - name: b
+`), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		sel  string
+		want interface{}
+	}{
+		{"$.db.host", "db.internal"},
+		{"$.db.port", 5432},
+		{"$.servers[1].name", "b"},
+		{"$.servers[2].name", nil},
+		{"$.servers[-1]", nil},
+		{"$.servers.name", nil},
+		{"$.db.host.name", nil},
+		{"$.missing", nil},
+	} {
+		got, err := selectKey(doc, tt.sel)
+		if err != nil {
+			t.Errorf("selectKey(%q): %s", tt.sel, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("selectKey(%q) = %#v, want %#v", tt.sel, got, tt.want)
+		}
+	}
+
+	if _, err := selectKey(doc, "$.db..host"); err == nil {
+		t.Error("selectKey with an invalid selector didn't fail")
+	}
+}
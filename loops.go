@@ -0,0 +1,221 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ignoreFlag     = flag.String("ignore", "", "glob patterns of paths whose changes are ignored, e.g. the child's own state files, split by ':'")
+	loopLimitFlag  = flag.Int("loop-limit", 10, "most actions within -loop-window before changes are treated as a feedback loop (0 disables)")
+	loopWindowFlag = flag.Duration("loop-window", time.Minute, "window -loop-limit applies to, and how long changes are held for once a loop is detected")
+)
+
+// ownWriteWindow is how long events for a file blart wrote itself
+// are ignored for.
+const ownWriteWindow = 2 * time.Second
+
+// ownWrites remembers the files blart has just written, and the ones
+// it writes continuously such as logs, so that the events they cause
+// aren't mistaken for changes.
+type ownWrites struct {
+	mu     sync.Mutex
+	until  map[string]time.Time
+	temps  map[string]time.Time
+	always map[string]bool
+}
+
+var selfWrites = &ownWrites{
+	until:  make(map[string]time.Time),
+	temps:  make(map[string]time.Time),
+	always: make(map[string]bool),
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+func (o *ownWrites) record(path string) {
+	o.mu.Lock()
+	o.until[absPath(path)] = time.Now().Add(ownWriteWindow)
+	o.mu.Unlock()
+}
+
+// recordTemp records the temp files about to be created in dir with
+// the given prefix, whose names aren't known until they exist.
+func (o *ownWrites) recordTemp(dir, prefix string) {
+	o.mu.Lock()
+	o.temps[absPath(filepath.Join(dir, prefix))] = time.Now().Add(ownWriteWindow)
+	o.mu.Unlock()
+}
+
+// own marks path as always written by blart.
+func (o *ownWrites) own(path string) {
+	o.mu.Lock()
+	o.always[absPath(path)] = true
+	o.mu.Unlock()
+}
+
+// contains reports whether the latest event for path was caused by
+// blart writing it.
+func (o *ownWrites) contains(path string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.expire()
+	if _, ok := o.until[absPath(path)]; ok {
+		return true
+	}
+	return o.ownedLocked(path)
+}
+
+// owned reports whether path is a file blart keeps writing to, or one
+// of its temp files, rather than a config it has just written.
+func (o *ownWrites) owned(path string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.expire()
+	return o.ownedLocked(path)
+}
+
+func (o *ownWrites) ownedLocked(path string) bool {
+	path = absPath(path)
+	if o.always[path] {
+		return true
+	}
+	for prefix := range o.temps {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *ownWrites) expire() {
+	now := time.Now()
+	for p, until := range o.until {
+		if now.After(until) {
+			delete(o.until, p)
+		}
+	}
+	for p, until := range o.temps {
+		if now.After(until) {
+			delete(o.temps, p)
+		}
+	}
+}
+
+// ignored reports whether path matches one of the -ignore patterns,
+// either as a whole or by its base name.
+func ignored(path string) bool {
+	if *ignoreFlag == "" {
+		return false
+	}
+	for _, pattern := range strings.Split(*ignoreFlag, ":") {
+		if pattern == "" {
+			continue
+		}
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// loopBreaker stops acting on changes when there have been more
+// actions than limit within window, which most likely means each one
+// is causing the next. Changes that arrive while it's paused are
+// applied once the pause is over, and the child isn't reported ready
+// in between, as it may be running stale config.
+type loopBreaker struct {
+	limit  int
+	window time.Duration
+	health *health
+
+	mu      sync.Mutex
+	times   []time.Time
+	paused  bool
+	actions []func()
+	missed  map[int]bool
+}
+
+// wrap returns fn guarded by the loop breaker.
+func (b *loopBreaker) wrap(fn func()) func() {
+	b.mu.Lock()
+	id := len(b.actions)
+	b.actions = append(b.actions, fn)
+	b.mu.Unlock()
+
+	return func() {
+		if !b.allow(id) {
+			b.health.reloadHeld()
+			return
+		}
+		fn()
+	}
+}
+
+func (b *loopBreaker) allow(id int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.paused {
+		log.Println("==> holding change until the suspected feedback loop pause is over")
+		b.missed[id] = true
+		return false
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-b.window)
+	recent := b.times[:0]
+	for _, t := range b.times {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	b.times = append(recent, now)
+
+	if len(b.times) > b.limit {
+		log.Printf("==> warning: %d changes acted on within %s, this looks like a feedback loop "+
+			"(is something blart or the child writes being watched? see -ignore); holding changes for %s",
+			len(b.times), b.window, b.window)
+		b.paused = true
+		b.times = nil
+		b.missed = map[int]bool{id: true}
+		b.health.setUnready("feedback loop suspected, changes held")
+		time.AfterFunc(b.window, b.resume)
+		return false
+	}
+	return true
+}
+
+// resume ends a pause, applying the changes held during it once.
+func (b *loopBreaker) resume() {
+	b.mu.Lock()
+	var missed []func()
+	for id := range b.actions {
+		if b.missed[id] {
+			missed = append(missed, b.actions[id])
+		}
+	}
+	b.paused = false
+	b.missed = nil
+	b.times = append(b.times, time.Now())
+	b.mu.Unlock()
+
+	b.health.setUnready("")
+	log.Println("==> feedback loop pause over, applying held changes")
+	for _, fn := range missed {
+		b.health.reloadStarted()
+		fn()
+	}
+}
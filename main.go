@@ -1,16 +1,16 @@
 package main
 
 import (
-	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
-	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
 
@@ -20,42 +20,59 @@ import (
 const Version = "0.1.0"
 
 var (
-	filesFlag = flag.String("f", "", "files and directories to watch, split by ':'")
+	filesFlag = flag.String("f", "", "files and directories to watch, split by ':', with optional key selectors, e.g. 'app.yaml#$.db,$.cache'")
 	sigFlag   = flag.String("s", "HUP", "signal to send on change")
 	delayFlag = flag.Duration("d", 3*time.Second, "time to wait after change before signalling child")
-)
 
-func signalByName(name string) (sig os.Signal, err error) {
-	var ok bool
-	if sig, ok = signals[strings.ToUpper(name)]; !ok {
-		err = errors.New(fmt.Sprintf("unknown signal: %s", name))
-	}
-	return
-}
+	stopSigFlag     = flag.String("stop-signal", "TERM", "signal to send when stopping the child for a restart")
+	stopTimeoutFlag = flag.Duration("stop-timeout", 5*time.Second, "time to wait for the child to stop before killing it")
+
+	exitOnChangeFlag = flag.Bool("exit-on-change", false, "stop the child and exit on change, leaving the restart to an orchestrator")
+	exitCodeFlag     = flag.Int("exit-code", 3, "code to exit with when -exit-on-change is set")
+
+	triggerFlag = flag.String("trigger", "", "signal that makes blart reload the child as if a file changed, instead of forwarding it")
+)
 
-func signalDebounce(process *os.Process, sig os.Signal, delay time.Duration) *sync.Cond {
-	var m sync.Mutex
-	cond := sync.NewCond(&m)
-	cond.L.Lock()
+// debounce runs fn delay after a change is sent on the returned
+// channel. merged is called for each change folded into a run that
+// was already going to happen.
+func debounce(delay time.Duration, fn func(), merged func()) chan<- struct{} {
+	// Holds at most one pending change, so a change that arrives
+	// while fn is running schedules exactly one more run of it.
+	changed := make(chan struct{}, 1)
 
 	go func() {
-		// continulously wait for a Broadcast event
-		// then sleep, and pass along the signal to the process
-		// The sleep causes the signals to effectively be debounced.
+		// continulously wait for a change event
+		// then sleep, and run the action
+		// The sleep causes the actions to effectively be debounced.
 		// Note: this isn't a true debounce. We don't want to trigger
 		// immediately on the first event. We explicitly want to wait
 		// _then_ trigger.
-		for {
-			cond.Wait()
+		for range changed {
 			time.Sleep(delay)
-			if process != nil {
-				log.Println("==> signalling child")
-				process.Signal(sig)
+			// changes during the sleep are covered by this run
+			select {
+			case <-changed:
+				merged()
+			default:
 			}
+			fn()
 		}
 	}()
 
-	return cond
+	return changed
+}
+
+// notify tells a debounced action that something has changed,
+// without blocking. It returns false if a run was already pending,
+// which the change is then covered by.
+func notify(changed chan<- struct{}) bool {
+	select {
+	case changed <- struct{}{}:
+		return true
+	default:
+		return false
+	}
 }
 
 func usageAndExit(s interface{}) {
@@ -63,32 +80,107 @@ func usageAndExit(s interface{}) {
 	flag.Usage()
 	fmt.Println()
 	fmt.Printf("%s version: %s (%s on %s/%s; %s)\n", os.Args[0], Version, runtime.Version(), runtime.GOOS, runtime.GOARCH, runtime.Compiler)
+	removePidFile()
 	os.Exit(1)
 }
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "usage: blart [flags] [command]\n")
+	fmt.Fprintf(os.Stderr, "       blart -control <addr> ctl status|approve|reject\n")
+	fmt.Fprintf(os.Stderr, "       blart -pidfile <file> ctl stop\n")
 	flag.PrintDefaults()
 }
 
 func init() {
 	flag.Usage = usage
-	flag.Parse()
 }
 
 func main() {
+	flag.Parse()
+
+	if flag.Arg(0) == "ctl" {
+		ctl(flag.Args()[1:])
+		return
+	}
+
+	if *capabilitiesFlag {
+		printCapabilities()
+		return
+	}
+
+	if err := validatePlatform(); err != nil {
+		usageAndExit(err)
+	}
+
 	sig, err := signalByName(*sigFlag)
 	if err != nil {
 		usageAndExit(err)
 	}
 
+	stopSig, err := signalByName(*stopSigFlag)
+	if err != nil {
+		usageAndExit(err)
+	}
+
+	var trigger os.Signal
+	if *triggerFlag != "" {
+		trigger, err = signalByName(*triggerFlag)
+		if err != nil {
+			usageAndExit(err)
+		}
+		// INT and TERM shut blart down and KILL can't be caught, while
+		// CHLD and URG arrive all the time, whenever a command blart
+		// ran exits and whenever the Go runtime preempts a goroutine.
+		// signals has neither on windows.
+		switch trigger {
+		case os.Interrupt, os.Kill, syscall.SIGTERM, signals["CHLD"], signals["URG"]:
+			usageAndExit(fmt.Sprintf("%s can't be used as a trigger", *triggerFlag))
+		}
+	}
+
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		usageAndExit(err)
 	}
 	defer watcher.Close()
 
-	if *filesFlag == "" {
+	var secrets map[string]string
+	if *fileEnvFlag {
+		_, secrets, err = fileEnv(os.Environ())
+		if err != nil {
+			usageAndExit(err)
+		}
+	}
+
+	err = validateStaging()
+	if err != nil {
+		usageAndExit(err)
+	}
+	err = validateSpool()
+	if err != nil {
+		usageAndExit(err)
+	}
+
+	targets := dialTargets()
+	for _, target := range targets {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			usageAndExit(err)
+		}
+	}
+	thresholds, err := parseThresholds(*certWarnFlag)
+	if err != nil {
+		usageAndExit(err)
+	}
+
+	if *approveFlag && *controlFlag == "" && *approveTimeoutFlag == 0 {
+		usageAndExit("-approve needs -control to approve changes with, or -approve-timeout")
+	}
+
+	if *dialThresholdFlag < 1 {
+		usageAndExit("-dial-threshold must be at least 1")
+	}
+
+	if *filesFlag == "" && len(secrets) == 0 && *stagingFlag == "" && len(targets) == 0 && *spoolFlag == "" {
 		usageAndExit("no files to watch")
 	}
 
@@ -96,8 +188,107 @@ func main() {
 		usageAndExit("no command specified")
 	}
 
+	if *daemonFlag && !daemonized() {
+		if *logFileFlag == "" {
+			usageAndExit("-daemon requires -log-file")
+		}
+		err = daemonize(*logFileFlag)
+		if err != nil {
+			fmt.Printf("!! %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Files blart keeps writing to never count as changes, even if
+	// they're inside a watched directory
+	for _, path := range []string{*logFileFlag, *childLogFileFlag, *pidFileFlag, *statusFileFlag} {
+		if path != "" {
+			selfWrites.own(path)
+		}
+	}
+
+	if *pidFileFlag != "" {
+		err = writePidFile(*pidFileFlag)
+		if err != nil {
+			usageAndExit(err)
+		}
+	}
+
+	// In spool mode the command is run once per job file, rather
+	// than kept running
+	if *spoolFlag != "" {
+		var output io.Writer
+		if *childLogFileFlag != "" {
+			output, err = openLog(*childLogFileFlag)
+			if err != nil {
+				usageAndExit(err)
+			}
+		}
+		err = runSpool(watcher, flag.Args(), output)
+		removePidFile()
+		if err != nil {
+			log.Println("==> error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var ln, controlLn net.Listener
+	if *httpFlag != "" {
+		ln, err = net.Listen("tcp", *httpFlag)
+		if err != nil {
+			usageAndExit(err)
+		}
+	}
+	if *controlFlag != "" {
+		controlLn, err = net.Listen("tcp", controlAddr(*controlFlag))
+		if err != nil {
+			usageAndExit(err)
+		}
+	}
+
+	var monkey *chaos
+	if *chaosFlag {
+		monkey, err = newChaos()
+		if err != nil {
+			usageAndExit(err)
+		}
+	}
+
 	// start watching files for changes
-	for _, file := range strings.Split(*filesFlag, ":") {
+	var files []string
+	keyed := make(map[string]*keyWatch)
+	if *filesFlag != "" {
+		for _, watch := range strings.Split(*filesFlag, ":") {
+			file, selectors := parseWatch(watch)
+			if len(selectors) > 0 {
+				k, err := newKeyWatch(file, selectors)
+				if err != nil {
+					usageAndExit(err)
+				}
+				keyed[file] = k
+			}
+			files = append(files, file)
+		}
+	}
+	// the config the child runs, as opposed to secrets and staging
+	// candidates
+	configs := append([]string(nil), files...)
+	if *liveFlag != "" {
+		configs = append(configs, *liveFlag)
+	}
+	for file := range secrets {
+		files = append(files, file)
+	}
+	err = setupRedaction(secrets)
+	if err != nil {
+		usageAndExit(err)
+	}
+	if *stagingFlag != "" {
+		files = append(files, *stagingFlag)
+	}
+	for _, file := range files {
 		err = watcher.Add(file)
 		// if a file doesn't exist that you're trying to watch at this
 		// point, it's likely a config error, and we should bail
@@ -106,42 +297,285 @@ func main() {
 		}
 	}
 
-	cmd := exec.Command(flag.Args()[0], flag.Args()[1:]...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err = cmd.Start()
+	// The fingerprint is passed in the environment, so it's only
+	// current as of the last time the child was started.
+	fp := newFingerprint(configs)
+	childEnv := func(fingerprint string) ([]string, error) {
+		env := os.Environ()
+		if *fileEnvFlag {
+			var err error
+			env, _, err = fileEnv(env)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return append(env, "BLART_FINGERPRINT="+fingerprint), nil
+	}
+	env, err := childEnv(fp.get())
 	if err != nil {
 		usageAndExit(err)
 	}
 
-	fmt.Println("==> starting child", strings.Join(flag.Args(), " "))
-
-	done := make(chan struct{})
+	// Signals are caught from here on, so that a shutdown never
+	// leaves the pidfile or an init step behind. Until the child has
+	// started, there's nothing else to stop.
+	c := make(chan os.Signal, 1)
+	signal.Notify(c)
+	initDone := make(chan struct{})
 	go func() {
-		cmd.Wait()
-		done <- struct{}{}
+		for {
+			select {
+			case sig := <-c:
+				switch sig {
+				case os.Interrupt, os.Kill, syscall.SIGTERM:
+					log.Printf("==> received %s during init, stopping", sig)
+					killInit()
+					removePidFile()
+					os.Exit(1)
+				}
+			case <-initDone:
+				return
+			}
+		}
 	}()
 
+	err = runInit(*initFlag)
+	close(initDone)
+	if err != nil {
+		log.Println("==> error:", err)
+		removePidFile()
+		os.Exit(1)
+	}
+
+	child := newChild(flag.Args(), env)
+	if *childLogFileFlag != "" {
+		out, err := openLog(*childLogFileFlag)
+		if err != nil {
+			usageAndExit(err)
+		}
+		child.output = out
+	}
+	err = child.start()
+	if err != nil {
+		usageAndExit(err)
+	}
+
+	fmt.Println("==> starting child", redaction.String(strings.Join(flag.Args(), " ")))
+
+	h := &health{}
+
+	if monkey != nil {
+		go monkey.run(child, sig)
+	}
+
+	// Secrets are only read when the child starts, so a change
+	// to one needs a restart rather than a signal.
+	restart := func() error {
+		sum := fp.compute()
+		env, err := childEnv(sum)
+		if err == nil && *initEachRestartFlag {
+			err = runInit(*initFlag)
+		}
+		if err == nil {
+			child.setEnv(env)
+			if *idleWaitFlag > 0 {
+				waitIdle(child, *idleWaitFlag)
+			}
+			log.Println("==> restarting child")
+			err = child.restart(stopSig, *stopTimeoutFlag)
+		}
+		if err != nil {
+			log.Println("==> error:", err)
+			return err
+		}
+		fp.set(sum)
+		return nil
+	}
+	restartChild := func() {
+		h.reloadFinished(restart())
+	}
+
+	limit := &restartLimit{max: *maxRestartsFlag, window: *restartWindowFlag}
+	reloadChild := func() {
+		log.Println("==> signalling child")
+		err := child.signal(sig)
+		if err == nil {
+			err = confirmReload(*confirmTimeoutFlag)
+		}
+		if err == nil {
+			sum := fp.update()
+			// so that later restarts carry the new fingerprint
+			if env, err := childEnv(sum); err == nil {
+				child.setEnv(env)
+			}
+		} else {
+			log.Println("==> error:", err)
+			if *fallbackRestartFlag {
+				if limit.allow() {
+					log.Println("==> reload failed, falling back to a restart")
+					err = restart()
+				} else {
+					log.Printf("==> reload failed, but already restarted %d times in %s", limit.max, limit.window)
+				}
+			}
+		}
+		h.reloadFinished(err)
+	}
+	if *exitOnChangeFlag {
+		exit := func() {
+			log.Println("==> stopping child")
+			child.stop(stopSig, *stopTimeoutFlag)
+			log.Printf("==> exiting with code %d", *exitCodeFlag)
+			removePidFile()
+			os.Exit(*exitCodeFlag)
+		}
+		reloadChild, restartChild = exit, exit
+	}
+
+	signalChild := func() {
+		if err := check(); err != nil {
+			log.Println("==> error:", err)
+			h.reloadFinished(err)
+			return
+		}
+		reloadChild()
+	}
+	promoteStaged := func() {
+		promoted, err := promote(*stagingFlag, *liveFlag)
+		if err != nil {
+			log.Println("==> error:", err)
+			h.reloadFinished(err)
+			return
+		}
+		if !promoted {
+			// nothing to do, or rejected, and either way the
+			// child's config is unchanged
+			h.reloadHeld()
+			return
+		}
+		reloadChild()
+	}
+
+	if *loopLimitFlag > 0 {
+		breaker := &loopBreaker{limit: *loopLimitFlag, window: *loopWindowFlag, health: h}
+		// held changes are applied in the order wrapped
+		promoteStaged = breaker.wrap(promoteStaged)
+		restartChild = breaker.wrap(restartChild)
+		signalChild = breaker.wrap(signalChild)
+	}
+
+	if *jitterFlag > 0 {
+		signalChild = withJitter(signalChild)
+		restartChild = withJitter(restartChild)
+		promoteStaged = withJitter(promoteStaged)
+	}
+
+	var gate *approval
+	if *approveFlag {
+		gate = newApproval(files, h)
+		signalChild = gate.gate("reload", signalChild)
+		restartChild = gate.gate("restart", restartChild)
+		promoteStaged = gate.gate("promotion", promoteStaged)
+	}
+
+	a := &api{child: child, health: h, gate: gate, fingerprint: fp}
+	if len(thresholds) > 0 {
+		a.certs = newCertMonitor(files, thresholds)
+		go a.certs.run(*certIntervalFlag)
+	}
+
+	if ln != nil {
+		go a.serveHealth(ln)
+	}
+	if controlLn != nil {
+		go a.serveControl(controlLn)
+	}
+	if *statusFileFlag != "" {
+		go writeStatusFile(*statusFileFlag, a.status)
+	}
+
+	// Create wrappers to debounce the change events
+	changed := debounce(*delayFlag, signalChild, h.reloadHeld)
+	secretChanged := debounce(*delayFlag, restartChild, h.reloadHeld)
+	staged := debounce(*delayFlag, promoteStaged, h.reloadHeld)
+
+	// queue marks a reload as in progress, which it stays until the
+	// run that covers it has finished
+	queue := func(changed chan<- struct{}) {
+		h.reloadStarted()
+		if !notify(changed) {
+			h.reloadHeld()
+		}
+	}
+
+	for _, target := range targets {
+		go watchReachability(target, *dialIntervalFlag, *dialThresholdFlag, func(target string, up bool) {
+			if up {
+				log.Println("==> detected", target, "became reachable")
+			} else {
+				log.Println("==> detected", target, "became unreachable")
+			}
+			queue(changed)
+		}, nil)
+	}
+
 	go func() {
 		var event fsnotify.Event
 		var err error
 
-		// Create wrapper to debounce the signal events
-		cond := signalDebounce(cmd.Process, sig, *delayFlag)
-
 		for {
 			select {
 			case event = <-watcher.Events:
-				log.Println("==> detected change in", event.Name)
-				// Broadcase to the sync.Cond that an event has happened.
-				// magic happens inside signalDebounce
-				cond.Broadcast()
-				if event.Op&fsnotify.Rename == fsnotify.Rename {
-					// File was renamed, so remove the old watch,
-					// and add a new one
+				if event.Op&(fsnotify.Rename|fsnotify.Remove) != 0 {
+					// File was renamed or replaced (Kubernetes swaps
+					// mounted secrets out this way), so remove the old
+					// watch, and add a new one
 					watcher.Remove(event.Name)
 					watcher.Add(event.Name)
 				}
+
+				if selfWrites.contains(event.Name) {
+					continue
+				}
+				if ignored(event.Name) {
+					log.Println("==> ignoring change in", event.Name)
+					continue
+				}
+				redaction.refresh(event.Name)
+
+				// Tell the debounced action that an event has happened.
+				// magic happens inside debounce
+				if name, ok := secrets[event.Name]; ok {
+					log.Println("==> detected change in secret", name)
+					queue(secretChanged)
+					continue
+				}
+
+				if *stagingFlag != "" && filepath.Dir(event.Name) == filepath.Clean(*stagingFlag) {
+					if stagedFile(event.Name) {
+						log.Println("==> detected candidate", event.Name)
+						queue(staged)
+					}
+					continue
+				}
+
+				if k, ok := keyed[event.Name]; ok {
+					changes, err := k.changes()
+					if err != nil {
+						log.Println("==> error:", err)
+						continue
+					}
+					if len(changes) == 0 {
+						log.Printf("==> ignoring change in %s, no watched keys changed", event.Name)
+						continue
+					}
+					for _, c := range changes {
+						log.Printf("==> detected change in %s: %s", event.Name, c)
+					}
+				} else {
+					log.Println("==> detected change in", event.Name)
+				}
+				queue(changed)
 			case err = <-watcher.Errors:
 				log.Println("==> error:", err)
 			}
@@ -149,41 +583,52 @@ func main() {
 	}()
 
 	// Listen to signals send to parent, and pass along to the child
-	c := make(chan os.Signal, 1)
-	signal.Notify(c)
 	go func() {
 		var sig os.Signal
 		for {
 			sig = <-c
-			cmd.Process.Signal(sig)
+			if trigger != nil && sig == trigger {
+				// run the same pipeline as a change to a watched file
+				log.Printf("==> received %s, triggering reload", strings.ToUpper(*triggerFlag))
+				queue(changed)
+				continue
+			}
+			child.signal(sig)
 			switch sig {
 			case os.Interrupt, os.Kill, syscall.SIGTERM:
-				countdown := 5 * time.Second
+				countdown := *stopTimeoutFlag
 
 				fmt.Println("==> attempting to shut down cleanly")
 				fmt.Printf("==> waiting up to %s for child to exit\n", countdown)
 
 				// try and wait for the child to shut down before killing
 				select {
-				case <-done:
+				case <-child.wait():
 				case <-time.After(countdown):
 				}
 
 				// it hasn't shut down yet, so attempt to SIGKILL
 				fmt.Println("==> attempting to now kill child")
-				cmd.Process.Signal(os.Kill)
+				child.signal(os.Kill)
 
 				select {
-				case <-done:
+				case <-child.wait():
 				case <-time.After(time.Second):
 				}
 
 				// still hasn't exited, so killing self
 				fmt.Println("==> now committing suicide")
+				killInit()
+				removePidFile()
 				os.Exit(1)
 			}
 		}
 	}()
 
-	<-done
+	// everything that can fail at startup has, so a -daemon parent
+	// can report success
+	daemonReady()
+
+	<-child.exited
+	removePidFile()
 }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// writeMetrics writes s, which only needs to be a summary, in the
+// Prometheus text format.
+func writeMetrics(w io.Writer, s status) {
+	gauge := func(name, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+	bool01 := func(b bool) int {
+		if b {
+			return 1
+		}
+		return 0
+	}
+
+	gauge("blart_child_up", "Whether the child is running.")
+	fmt.Fprintf(w, "blart_child_up %d\n", bool01(s.Running))
+	gauge("blart_ready", "Whether the child is ready, with no reload in progress or failed.")
+	fmt.Fprintf(w, "blart_ready %d\n", bool01(s.Ready))
+
+	gauge("blart_config_info", "The fingerprint of the config the child last successfully reloaded.")
+	fmt.Fprintf(w, "blart_config_info{fingerprint=%s} 1\n", labelValue(s.Fingerprint))
+
+	if len(s.Certs) > 0 {
+		gauge("blart_cert_expiry_days", "Days until a watched certificate expires.")
+		for _, c := range s.Certs {
+			fmt.Fprintf(w, "blart_cert_expiry_days{file=%s} %d\n", labelValue(c.File), c.DaysLeft)
+		}
+	}
+}
+
+// labelEscaper escapes what the Prometheus text format requires of a
+// label value, and nothing else, so that non-ASCII paths stay as is.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelValue(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
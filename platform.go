@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+var capabilitiesFlag = flag.Bool("capabilities", false, "print what this platform supports and exit")
+
+// capability is an action that only some platforms support. Each
+// platform_<os>.go declares which it has, next to its signals.
+type capability string
+
+const (
+	capSignals       capability = "signals"
+	capProcessGroups capability = "process groups"
+	capProcfs        capability = "procfs"
+	capDaemon        capability = "daemon"
+)
+
+// allSignals is every signal name known on any platform, so that one
+// missing from this platform can be told apart from a typo.
+var allSignals = []string{
+	"ABRT", "ALRM", "BUS", "CHLD", "CONT", "EMT", "FPE", "HUP", "ILL",
+	"INFO", "INT", "IO", "IOT", "KILL", "PIPE", "PROF", "QUIT", "SEGV",
+	"STOP", "SYS", "TERM", "TRAP", "TSTP", "TTIN", "TTOU", "URG", "USR1",
+	"USR2", "VTALRM", "WINCH", "XCPU", "XFSZ",
+}
+
+func signalByName(name string) (os.Signal, error) {
+	upper := strings.ToUpper(name)
+	if sig, ok := signals[upper]; ok {
+		return sig, nil
+	}
+	for _, s := range allSignals {
+		if s == upper {
+			return nil, fmt.Errorf("signal %s is not available on %s", upper, runtime.GOOS)
+		}
+	}
+	return nil, fmt.Errorf("unknown signal: %s", name)
+}
+
+func supports(c capability) bool {
+	return capabilities[c]
+}
+
+func requireCapability(c capability, feature string) error {
+	if !supports(c) {
+		return fmt.Errorf("%s needs %s, which %s doesn't support", feature, c, runtime.GOOS)
+	}
+	return nil
+}
+
+// validatePlatform fails early for any requested feature this
+// platform can't provide, rather than leaving it to fail at runtime.
+func validatePlatform() error {
+	type requirement struct {
+		requested bool
+		c         capability
+		feature   string
+	}
+	// spool jobs are only ever waited on, and KILL works everywhere
+	supervising := *spoolFlag == ""
+	stopSig := strings.ToUpper(*stopSigFlag)
+	for _, r := range []requirement{
+		{supervising && !*exitOnChangeFlag, capSignals, "signalling the child with -s (use -exit-on-change -stop-signal KILL instead)"},
+		{supervising && stopSig != "KILL", capSignals, "stopping the child with -stop-signal " + stopSig + " (use -stop-signal KILL instead)"},
+		{*triggerFlag != "", capSignals, "-trigger"},
+		{*chaosSignalsFlag != "", capSignals, "-chaos-signals"},
+		{*idleWaitFlag > 0, capProcfs, "-idle-wait"},
+		{*daemonFlag, capDaemon, "-daemon"},
+	} {
+		if r.requested {
+			if err := requireCapability(r.c, r.feature); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func printCapabilities() {
+	names := make([]string, 0, len(capabilities))
+	for c := range capabilities {
+		names = append(names, string(c))
+	}
+	sort.Strings(names)
+
+	fmt.Printf("%s/%s:\n", runtime.GOOS, runtime.GOARCH)
+	for _, name := range names {
+		mark := "no"
+		if capabilities[capability(name)] {
+			mark = "yes"
+		}
+		fmt.Printf("  %-15s %s\n", name, mark)
+	}
+
+	var sigs []string
+	for name := range signals {
+		sigs = append(sigs, name)
+	}
+	sort.Strings(sigs)
+	fmt.Printf("  %-15s %s\n", "signal names", strings.Join(sigs, " "))
+}
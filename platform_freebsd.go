@@ -37,3 +37,10 @@ var signals = map[string]os.Signal{
 	"XCPU":   syscall.SIGXCPU,
 	"XFSZ":   syscall.SIGXFSZ,
 }
+
+var capabilities = map[capability]bool{
+	capSignals:       true,
+	capProcessGroups: true,
+	capProcfs:        false,
+	capDaemon:        true,
+}
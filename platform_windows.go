@@ -20,3 +20,10 @@ var signals = map[string]os.Signal{
 	"TERM": syscall.SIGTERM,
 	"TRAP": syscall.SIGTRAP,
 }
+
+var capabilities = map[capability]bool{
+	capSignals:       false,
+	capProcessGroups: false,
+	capProcfs:        false,
+	capDaemon:        false,
+}
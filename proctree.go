@@ -0,0 +1,12 @@
+package main
+
+// proc is a process in the child's process tree.
+type proc struct {
+	Pid       int     `json:"pid"`
+	Cmdline   string  `json:"cmdline"`
+	State     string  `json:"state"`
+	RSS       int64   `json:"rss_bytes"`
+	Threads   int     `json:"threads"`
+	LeftGroup bool    `json:"left_group,omitempty"`
+	Children  []*proc `json:"children,omitempty"`
+}
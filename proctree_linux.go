@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type procStat struct {
+	ppid, pgrp int
+	proc
+}
+
+// processTree reads the tree of processes rooted at pid from /proc.
+// Processes that have moved out of the root's process group are
+// marked as having left it.
+func processTree(pid int) (*proc, error) {
+	dirs, err := filepath.Glob("/proc/[0-9]*")
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(map[int]*procStat)
+	children := make(map[int][]int)
+	for _, dir := range dirs {
+		p, err := strconv.Atoi(filepath.Base(dir))
+		if err != nil {
+			continue
+		}
+		// processes can exit while we're reading
+		st, err := readProcStat(p)
+		if err != nil {
+			continue
+		}
+		stats[p] = st
+		children[st.ppid] = append(children[st.ppid], p)
+	}
+
+	root, ok := stats[pid]
+	if !ok {
+		return nil, fmt.Errorf("process %d not found", pid)
+	}
+	var build func(p int) *proc
+	build = func(p int) *proc {
+		st := stats[p]
+		node := st.proc
+		node.LeftGroup = st.pgrp != root.pgrp
+		for _, c := range children[p] {
+			node.Children = append(node.Children, build(c))
+		}
+		return &node
+	}
+	return build(pid), nil
+}
+
+func readProcStat(pid int) (*procStat, error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	// the command name is in parens and may itself contain spaces
+	// or parens, so split after the last one
+	s := string(b)
+	i := strings.LastIndex(s, ")")
+	if i < 0 {
+		return nil, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(s[i+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+
+	st := &procStat{}
+	st.Pid = pid
+	st.State = fields[0]
+	st.ppid, _ = strconv.Atoi(fields[1])
+	st.pgrp, _ = strconv.Atoi(fields[2])
+	st.Threads, _ = strconv.Atoi(fields[17])
+	rss, _ := strconv.ParseInt(fields[21], 10, 64)
+	st.RSS = rss * int64(os.Getpagesize())
+
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err == nil {
+		st.Cmdline = strings.TrimSpace(strings.Replace(string(cmdline), "\x00", " ", -1))
+	}
+	if st.Cmdline == "" {
+		// kernel threads and zombies have no command line
+		st.Cmdline = "[" + s[strings.Index(s, "(")+1:i] + "]"
+	}
+	return st, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func processTree(pid int) (*proc, error) {
+	return nil, errors.New("process trees are only supported on linux")
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	redactPathsFlag  = flag.String("redact-paths", "", "files whose contents are never shown, and are redacted from everything blart outputs, split by ':'")
+	redactEnvFlag    = flag.String("redact-env", "", "environment variables whose values are redacted from everything blart outputs, split by ','")
+	redactRegexpFlag = stringListFlag("redact-regexp", "regular expression of values to redact from everything blart outputs, may be given more than once")
+)
+
+const redacted = "[REDACTED]"
+
+// minSecretLen is the shortest value redacted by value. Anything
+// shorter, like a "1" or "true" in a secret file, would redact far
+// more than the secret.
+const minSecretLen = 4
+
+// redactor is the one place secrets are scrubbed from what blart
+// outputs: log lines, hook output, diffs and status documents. Secret
+// files are hidden by path, and their contents, along with any other
+// known secret values, are redacted wherever they appear.
+type redactor struct {
+	mu       sync.RWMutex
+	paths    map[string]bool
+	values   map[string]bool
+	patterns []*regexp.Regexp
+}
+
+var redaction = &redactor{
+	paths:  make(map[string]bool),
+	values: make(map[string]bool),
+}
+
+// setupRedaction configures redaction from the flags and the files
+// resolved by -file-env, then routes blart's log through it. Values
+// selected by -redact-keys are added as keyed files are read, and the
+// rest of those files is shown as usual.
+func setupRedaction(secrets map[string]string) error {
+	for _, expr := range *redactRegexpFlag {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("-redact-regexp: %s", err)
+		}
+		redaction.patterns = append(redaction.patterns, re)
+	}
+	if *redactPathsFlag != "" {
+		for _, path := range strings.Split(*redactPathsFlag, ":") {
+			if path != "" {
+				redaction.hidePath(path)
+			}
+		}
+	}
+	if *redactEnvFlag != "" {
+		for _, name := range strings.Split(*redactEnvFlag, ",") {
+			redaction.addValue(os.Getenv(name))
+		}
+	}
+	for path := range secrets {
+		redaction.hidePath(path)
+	}
+
+	log.SetOutput(redaction.writer(os.Stderr))
+	return nil
+}
+
+// hidePath hides the contents of path, and redacts them as a value.
+func (r *redactor) hidePath(path string) {
+	r.mu.Lock()
+	r.paths[absPath(path)] = true
+	r.mu.Unlock()
+	r.refresh(path)
+}
+
+// refresh re-reads path if it's hidden, so that its new contents are
+// redacted too. Old contents stay redacted.
+func (r *redactor) refresh(path string) {
+	if !r.hidden(path) {
+		return
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	r.addValue(strings.TrimRight(string(b), "\r\n"))
+}
+
+func (r *redactor) addValue(v string) {
+	v = strings.TrimSpace(v)
+	if len(v) < minSecretLen {
+		return
+	}
+	r.mu.Lock()
+	r.values[v] = true
+	r.mu.Unlock()
+}
+
+// hidden reports whether the contents of path must never be shown.
+func (r *redactor) hidden(path string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.paths[absPath(path)]
+}
+
+// String returns s with every known secret value, and everything
+// matching -redact-regexp, replaced.
+func (r *redactor) String(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// longest first, so that a secret containing another is
+	// redacted whole
+	values := make([]string, 0, len(r.values))
+	for v := range r.values {
+		values = append(values, v)
+	}
+	sort.Sort(byLength(values))
+	for _, v := range values {
+		s = strings.Replace(s, v, redacted, -1)
+	}
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, redacted)
+	}
+	return s
+}
+
+type byLength []string
+
+func (s byLength) Len() int           { return len(s) }
+func (s byLength) Less(i, j int) bool { return len(s[i]) > len(s[j]) }
+func (s byLength) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// writer returns w with everything written to it redacted. Each write
+// is redacted on its own, which suits the log and the line by line
+// output of hooks.
+func (r *redactor) writer(w io.Writer) io.Writer {
+	return redactWriter{r, w}
+}
+
+type redactWriter struct {
+	r *redactor
+	w io.Writer
+}
+
+func (w redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, w.r.String(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// startRedacted starts cmd with its output redacted. The output is
+// copied from pipes rather than handed to exec as a writer, which
+// would make cmd.Wait wait for anything cmd started that still holds
+// them open, long after cmd itself has exited or been killed.
+func startRedacted(cmd *exec.Cmd) error {
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		outR.Close()
+		outW.Close()
+		return err
+	}
+	cmd.Stdout, cmd.Stderr = outW, errW
+	err = cmd.Start()
+	// the command has its own copies now
+	outW.Close()
+	errW.Close()
+	if err != nil {
+		outR.Close()
+		errR.Close()
+		return err
+	}
+	go copyRedacted(os.Stdout, outR)
+	go copyRedacted(os.Stderr, errR)
+	return nil
+}
+
+func copyRedacted(w io.Writer, r *os.File) {
+	io.Copy(redaction.writer(w), r)
+	r.Close()
+}
+
+// status redacts the parts of a status document that come from
+// outside of blart. Pending diffs are redacted as they're made.
+func (r *redactor) status(s *status) {
+	s.Reason = r.String(s.Reason)
+	r.tree(s.Tree)
+}
+
+func (r *redactor) tree(p *proc) {
+	if p == nil {
+		return
+	}
+	p.Cmdline = r.String(p.Cmdline)
+	for _, c := range p.Children {
+		r.tree(c)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestRedactorString(t *testing.T) {
+	r := &redactor{
+		paths:    make(map[string]bool),
+		values:   make(map[string]bool),
+		patterns: []*regexp.Regexp{regexp.MustCompile(`token=\w+`)},
+	}
+	r.addValue("hunter22")
+	r.addValue("  hunter2233\n")
+	r.addValue("abc") // too short to redact by value
+
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"nothing secret", "nothing secret"},
+		{"password hunter22", "password [REDACTED]"},
+		// the longer secret is redacted whole, not as the shorter
+		// one with a suffix left over
+		{"password hunter2233", "password [REDACTED]"},
+		{"hunter22hunter22", "[REDACTED][REDACTED]"},
+		{"abc", "abc"},
+		{"GET /?token=s3cr3t&x=1", "GET /?[REDACTED]&x=1"},
+	} {
+		if got := r.String(tt.in); got != tt.want {
+			t.Errorf("String(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"os/exec"
+	"runtime"
+)
+
+// shellCommand builds a command to run s with the system shell.
+func shellCommand(s string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", s)
+	}
+	return exec.Command("/bin/sh", "-c", s)
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+var (
+	spoolFlag            = flag.String("spool", "", "directory of job files, each moved into processing/ and passed to the command once completely written, then moved into done/ or failed/")
+	spoolConcurrencyFlag = flag.Int("spool-concurrency", 1, "most -spool jobs run at once")
+	spoolStableFlag      = flag.Duration("spool-stable", 2*time.Second, "time a -spool file must go unchanged before it's considered completely written")
+)
+
+// spoolState is what a spool file looked like when last scanned.
+type spoolState struct {
+	size  int64
+	mod   time.Time
+	since time.Time
+}
+
+// spool runs a command for each file dropped into a directory, once
+// it has stopped changing. Each file is claimed by moving it into
+// processing/ first, so it's never handled twice, even across
+// restarts, and then moved into done/ or failed/ depending on how the
+// command exited.
+type spool struct {
+	dir    string
+	args   []string
+	output io.Writer
+	stable time.Duration
+	slots  chan struct{}
+	stop   chan struct{}
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	pending map[string]spoolState
+	// handled are files that have been picked up, but not yet
+	// claimed
+	handled map[string]bool
+}
+
+// spoolModeFlags are the only flags that mean anything in spool mode.
+var spoolModeFlags = map[string]bool{
+	"spool":             true,
+	"spool-concurrency": true,
+	"spool-stable":      true,
+	"daemon":            true,
+	"log-file":          true,
+	"child-log-file":    true,
+	"pidfile":           true,
+}
+
+func validateSpool() error {
+	if *spoolFlag == "" {
+		return nil
+	}
+	var unused []string
+	flag.Visit(func(f *flag.Flag) {
+		if !spoolModeFlags[f.Name] {
+			unused = append(unused, "-"+f.Name)
+		}
+	})
+	if len(unused) > 0 {
+		return fmt.Errorf("%s can't be used with -spool", strings.Join(unused, ", "))
+	}
+	if *spoolConcurrencyFlag < 1 {
+		return errors.New("-spool-concurrency must be at least 1")
+	}
+	info, err := os.Stat(*spoolFlag)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("-spool %s is not a directory", *spoolFlag)
+	}
+	return nil
+}
+
+func newSpool(dir string, args []string, concurrency int, stable time.Duration) (*spool, error) {
+	for _, sub := range []string{"processing", "done", "failed"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &spool{
+		dir:     dir,
+		args:    args,
+		stable:  stable,
+		slots:   make(chan struct{}, concurrency),
+		stop:    make(chan struct{}),
+		pending: make(map[string]spoolState),
+		handled: make(map[string]bool),
+	}, nil
+}
+
+// runSpool processes the -spool directory until blart is told to stop,
+// then waits for the jobs in progress.
+func runSpool(watcher *fsnotify.Watcher, args []string, output io.Writer) error {
+	s, err := newSpool(*spoolFlag, args, *spoolConcurrencyFlag, *spoolStableFlag)
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(s.dir); err != nil {
+		return err
+	}
+	s.output = output
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	interval := s.stable / 2
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	if err := s.recover(); err != nil {
+		return err
+	}
+
+	daemonReady()
+	log.Println("==> processing spool", s.dir)
+	// files already there are picked up like any other, once they're
+	// known to be stable
+	s.scan()
+	for {
+		select {
+		case <-watcher.Events:
+			s.scan()
+		case <-ticker.C:
+			s.scan()
+		case err := <-watcher.Errors:
+			log.Println("==> error:", err)
+		case sig := <-stop:
+			log.Printf("==> received %s, waiting for jobs in progress", sig)
+			// jobs still waiting for a slot are left for next time
+			close(s.stop)
+			s.wg.Wait()
+			return nil
+		}
+	}
+}
+
+// scan looks for files that have stopped changing, and starts a job
+// for each of them.
+func (s *spool) scan() {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		log.Println("==> error:", err)
+		return
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	present := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		name := info.Name()
+		// dot files are the usual way of writing a file before
+		// renaming it into place
+		if !info.Mode().IsRegular() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		present[name] = true
+		if s.handled[name] {
+			continue
+		}
+
+		last, ok := s.pending[name]
+		if !ok || last.size != info.Size() || !last.mod.Equal(info.ModTime()) {
+			s.pending[name] = spoolState{size: info.Size(), mod: info.ModTime(), since: now}
+			continue
+		}
+		if now.Sub(last.since) < s.stable {
+			continue
+		}
+
+		delete(s.pending, name)
+		s.handled[name] = true
+		s.wg.Add(1)
+		go s.process(name)
+	}
+
+	// forget files that went away before they settled
+	for name := range s.pending {
+		if !present[name] {
+			delete(s.pending, name)
+		}
+	}
+}
+
+func (s *spool) process(name string) {
+	defer s.wg.Done()
+	select {
+	case s.slots <- struct{}{}:
+	case <-s.stop:
+		return
+	}
+	defer func() { <-s.slots }()
+	// both may have been ready, and either chosen
+	select {
+	case <-s.stop:
+		return
+	default:
+	}
+
+	path, err := s.claim(name)
+	if err != nil {
+		log.Println("==> error:", err)
+		return
+	}
+	log.Println("==> processing", path)
+
+	cmd := exec.Command(s.args[0], append(s.args[1:], path)...)
+	cmd.Env = append(os.Environ(), "BLART_SPOOL_FILE="+path)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if s.output != nil {
+		cmd.Stdout, cmd.Stderr = s.output, s.output
+	}
+
+	dest := "done"
+	if err := cmd.Run(); err != nil {
+		log.Printf("==> error: %s: %s", path, err)
+		dest = "failed"
+	}
+
+	moved, err := moveUnique(path, filepath.Join(s.dir, dest))
+	switch {
+	case os.IsNotExist(err):
+		log.Println("==> job removed", path, "itself")
+	case err != nil:
+		// it's out of the inbox either way, so it won't run again
+		log.Println("==> error:", err)
+	default:
+		log.Println("==> moved", path, "to", moved)
+	}
+}
+
+// claim moves a stable file out of the inbox into processing/, before
+// its job runs.
+func (s *spool) claim(name string) (string, error) {
+	path, err := moveUnique(filepath.Join(s.dir, name), filepath.Join(s.dir, "processing"))
+	s.mu.Lock()
+	delete(s.handled, name)
+	s.mu.Unlock()
+	return path, err
+}
+
+// recover moves the jobs that were running when blart last stopped
+// into failed/. They may have been partly done, so aren't run again.
+func (s *spool) recover() error {
+	infos, err := ioutil.ReadDir(filepath.Join(s.dir, "processing"))
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		path := filepath.Join(s.dir, "processing", info.Name())
+		moved, err := moveUnique(path, filepath.Join(s.dir, "failed"))
+		if err != nil {
+			return err
+		}
+		log.Println("==> job for", path, "was interrupted, moved to", moved)
+	}
+	return nil
+}
+
+// moveUnique moves path into dir, adding a suffix to its name if dir
+// already has a file by that name.
+func moveUnique(path, dir string) (string, error) {
+	base := filepath.Base(path)
+	dest := filepath.Join(dir, base)
+	for i := 1; ; i++ {
+		_, err := os.Lstat(dest)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		dest = filepath.Join(dir, fmt.Sprintf("%s.%d", base, i))
+	}
+	return dest, os.Rename(path, dest)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	checkFlag        = flag.String("check", "", "shell command that must succeed before a change is applied, run with BLART_STAGING set when validating -staging candidates")
+	checkTimeoutFlag = flag.Duration("check-timeout", time.Minute, "time -check may run before it's killed and counts as failed")
+	stagingFlag      = flag.String("staging", "", "directory of candidate configs, promoted into -live once -check passes")
+	liveFlag         = flag.String("live", "", "symlink to the directory candidates from -staging are promoted into, pointed at a new copy of it on each promotion")
+)
+
+// errorSuffix is appended to a rejected candidate's name for the
+// report written next to it.
+const errorSuffix = ".error"
+
+// check runs the -check command, if any. extra is added to the
+// command's environment. Before a plain reload it checks the watched
+// files in place, and before a promotion it's given BLART_STAGING, the
+// directory of candidates to check instead.
+func check(extra ...string) error {
+	if *checkFlag == "" {
+		return nil
+	}
+	var out bytes.Buffer
+	cmd := shellCommand(*checkFlag)
+	cmd.Env = append(os.Environ(), extra...)
+	cmd.Stdout, cmd.Stderr = &out, &out
+	setGroup(cmd)
+	err := cmd.Start()
+	if err == nil {
+		err = waitTimeout(cmd, *checkTimeoutFlag)
+	}
+	if err != nil {
+		if out := strings.TrimSpace(out.String()); out != "" {
+			return fmt.Errorf("check failed: %s\n%s", err, out)
+		}
+		return fmt.Errorf("check failed: %s", err)
+	}
+	return nil
+}
+
+// stagedFile reports whether name is a candidate in the staging
+// directory, rather than an error report or temporary file.
+func stagedFile(name string) bool {
+	base := filepath.Base(name)
+	return !strings.HasPrefix(base, ".") && !strings.HasSuffix(base, errorSuffix)
+}
+
+func candidates(staging string) ([]string, error) {
+	infos, err := ioutil.ReadDir(staging)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if info.Mode().IsRegular() && stagedFile(info.Name()) {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+// promote validates the candidates in staging and, if they pass,
+// promotes them into live all at once. Rejected candidates are left
+// in place with a report next to each of them, and live is left as it
+// was. It returns false if nothing was promoted.
+func promote(staging, live string) (bool, error) {
+	names, err := candidates(staging)
+	if err != nil || len(names) == 0 {
+		return false, err
+	}
+
+	log.Printf("==> validating %d candidates in %s", len(names), staging)
+	if err := check("BLART_STAGING=" + staging); err != nil {
+		report := fmt.Sprintf("rejected at %s\n\n%s\n", time.Now().Format(time.RFC3339), redaction.String(err.Error()))
+		for _, name := range names {
+			path := filepath.Join(staging, name+errorSuffix)
+			selfWrites.record(path)
+			if werr := ioutil.WriteFile(path, []byte(report), 0644); werr != nil {
+				log.Println("==> error:", werr)
+			}
+		}
+		log.Printf("==> rejected candidates in %s, %s is unchanged: %s", staging, live, err)
+		return false, nil
+	}
+
+	if err := swapLive(live, staging, names); err != nil {
+		return false, fmt.Errorf("promoting candidates in %s: %s", staging, err)
+	}
+	for _, name := range names {
+		log.Printf("==> promoted %s into %s", name, live)
+		for _, path := range []string{filepath.Join(staging, name), filepath.Join(staging, name+errorSuffix)} {
+			selfWrites.record(path)
+			os.Remove(path)
+		}
+	}
+	return true, nil
+}
+
+// swapLive promotes names from staging into live in one step. live is
+// a symlink to a directory, so a new version of that directory is
+// built next to it, and the symlink is replaced with a rename, which
+// readers see either all or none of.
+func swapLive(live, staging string, names []string) error {
+	old, err := os.Readlink(live)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(live)
+	if err != nil {
+		return err
+	}
+
+	parent := filepath.Dir(live)
+	prefix := "." + filepath.Base(live) + "."
+	selfWrites.recordTemp(parent, prefix)
+	next, err := ioutil.TempDir(parent, prefix)
+	if err != nil {
+		return err
+	}
+	link := next + ".link"
+
+	// anything failing from here on leaves live as it was
+	err = os.Chmod(next, info.Mode().Perm())
+	if err == nil {
+		err = copyDir(live, next)
+	}
+	for _, name := range names {
+		if err == nil {
+			err = copyFile(filepath.Join(staging, name), filepath.Join(next, name))
+		}
+	}
+	if err == nil {
+		err = os.Symlink(filepath.Base(next), link)
+	}
+	if err == nil {
+		// the old version's files going away from under a watch on
+		// live aren't changes either
+		if infos, err := ioutil.ReadDir(next); err == nil {
+			for _, info := range infos {
+				selfWrites.record(filepath.Join(live, info.Name()))
+			}
+		}
+		selfWrites.record(live)
+		err = os.Rename(link, live)
+	}
+	if err != nil {
+		os.Remove(link)
+		os.RemoveAll(next)
+		return err
+	}
+
+	// versions blart made are cleaned up once replaced, but one the
+	// user pointed live at is left alone
+	if !filepath.IsAbs(old) {
+		old = filepath.Join(parent, old)
+	}
+	if filepath.Dir(old) == parent && strings.HasPrefix(filepath.Base(old), prefix) {
+		os.RemoveAll(old)
+	}
+	return nil
+}
+
+// copyDir copies the contents of src into the existing directory dst.
+func copyDir(src, dst string) error {
+	infos, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		from, to := filepath.Join(src, info.Name()), filepath.Join(dst, info.Name())
+		switch {
+		case info.Mode().IsRegular():
+			err = copyFile(from, to)
+		case info.IsDir():
+			err = os.Mkdir(to, info.Mode().Perm())
+			if err == nil {
+				err = copyDir(from, to)
+			}
+		case info.Mode()&os.ModeSymlink != 0:
+			var target string
+			target, err = os.Readlink(from)
+			if err == nil {
+				err = os.Symlink(target, to)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies src to dst, replacing dst if it exists.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(dst, info.Mode().Perm())
+	}
+	return err
+}
+
+func validateStaging() error {
+	if *stagingFlag == "" && *liveFlag == "" {
+		return nil
+	}
+	if *stagingFlag == "" || *liveFlag == "" {
+		return errors.New("-staging and -live must be used together")
+	}
+	// promotions replace the symlink, never the files inside it
+	info, err := os.Lstat(*liveFlag)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("-live %s must be a symlink to a directory, so promotions can replace it in one step", *liveFlag)
+	}
+	for _, dir := range []string{*stagingFlag, *liveFlag} {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+	}
+	return nil
+}
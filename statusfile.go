@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var statusFileFlag = flag.String("status-file", "", "file to periodically write the status document to")
+
+const statusFileInterval = 5 * time.Second
+
+// writeStatusFile writes the output of current to path every
+// statusFileInterval, replacing the file atomically each time.
+func writeStatusFile(path string, current func() status) {
+	for {
+		if err := writeJSON(path, current()); err != nil {
+			log.Println("==> error:", err)
+		}
+		time.Sleep(statusFileInterval)
+	}
+}
+
+func writeJSON(path string, v interface{}) error {
+	selfWrites.recordTemp(filepath.Dir(path), "."+filepath.Base(path))
+	selfWrites.record(path)
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	err = encodeJSON(f, v)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// encodeJSON writes v indented, and without escaping the '&', '<'
+// and '>' common in command lines.
+func encodeJSON(w io.Writer, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = unescapeHTML(b)
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// unescapeHTML undoes the escaping of '&', '<' and '>' that
+// encoding/json always does, leaving escaped backslashes alone.
+func unescapeHTML(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] != '\\' || i+1 >= len(b) {
+			out = append(out, b[i])
+			continue
+		}
+		if b[i+1] == 'u' && i+6 <= len(b) {
+			switch string(b[i+2 : i+6]) {
+			case "0026":
+				out = append(out, '&')
+				i += 5
+				continue
+			case "003c":
+				out = append(out, '<')
+				i += 5
+				continue
+			case "003e":
+				out = append(out, '>')
+				i += 5
+				continue
+			}
+		}
+		// any other escape is copied whole, so that the backslash
+		// of an escaped backslash isn't taken as the start of one
+		out = append(out, b[i], b[i+1])
+		i++
+	}
+	return out
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestUnescapeHTML(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{`"a \u0026\u0026 b"`, `"a && b"`},
+		{`"\u003cnone\u003e"`, `"<none>"`},
+		{`"é\n\t\""`, `"é\n\t\""`},
+		// an escaped backslash followed by text that looks like an
+		// escape isn't one
+		{`"\\u0026"`, `"\\u0026"`},
+		{`"\\&"`, `"\\&"`},
+		{`\`, `\`},
+	} {
+		if got := string(unescapeHTML([]byte(tt.in))); got != tt.want {
+			t.Errorf("unescapeHTML(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeJSONRoundTrip(t *testing.T) {
+	in := map[string]string{"cmdline": `sh -c "a && b < c > d" & \\`}
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, in); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte(`\u003c`)) {
+		t.Errorf("encodeJSON escaped '<': %s", buf.Bytes())
+	}
+	var out map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out["cmdline"] != in["cmdline"] {
+		t.Errorf("round trip = %q, want %q", out["cmdline"], in["cmdline"])
+	}
+}